@@ -0,0 +1,191 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sources of answers recorded in the query log
+const (
+	SourceMesos    = "mesos"
+	SourceUpstream = "upstream"
+	SourceLocal    = "local"
+)
+
+// QueryEntry is a single line of the query log
+type QueryEntry struct {
+	Time    time.Time `json:"time"`
+	Client  string    `json:"client"`
+	Proto   string    `json:"proto"`
+	Name    string    `json:"qname"`
+	Type    string    `json:"qtype"`
+	Rcode   string    `json:"rcode"`
+	Answers int       `json:"answers"`
+	Latency float64   `json:"latency_ms"`
+	Source  string    `json:"source"`
+}
+
+// QueryLog writes a sample of the answered queries as JSON lines to a
+// size-rotated file. A nil *QueryLog is a disabled log.
+type QueryLog struct {
+	sync.Mutex
+	sample     float64
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	w          *bufio.Writer
+	size       int64
+	done       chan struct{}
+}
+
+// queryLogFlush is how often buffered entries are written out
+const queryLogFlush = time.Second
+
+// NewQueryLog opens (or creates) the query log at path. sample is the
+// fraction of queries logged; the file is rotated once it grows beyond
+// maxSize bytes, keeping maxBackups older files as path.1, path.2, ...
+func NewQueryLog(path string, sample float64, maxSize int64, maxBackups int) (*QueryLog, error) {
+	if sample <= 0 || sample > 1 {
+		return nil, fmt.Errorf("query log sample rate must be in (0, 1], got %v", sample)
+	}
+
+	q := &QueryLog{
+		sample:     sample,
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		done:       make(chan struct{}),
+	}
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+
+	go q.flushLoop()
+	return q, nil
+}
+
+// Sampled reports whether the next query should be logged; callers use it
+// to skip building entries that would be dropped
+func (q *QueryLog) Sampled() bool {
+	if q == nil {
+		return false
+	}
+	return q.sample >= 1 || rand.Float64() < q.sample
+}
+
+// Log appends e to the query log
+func (q *QueryLog) Log(e QueryEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		Error.Println(err)
+		return
+	}
+	b = append(b, '\n')
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.file == nil {
+		return
+	}
+	if q.maxSize > 0 && q.size+int64(len(b)) > q.maxSize && q.size > 0 {
+		if err := q.rotate(); err != nil {
+			Error.Println(err)
+			return
+		}
+	}
+
+	n, err := q.w.Write(b)
+	q.size += int64(n)
+	if err != nil {
+		Error.Println(err)
+	}
+}
+
+// Close flushes and closes the query log
+func (q *QueryLog) Close() error {
+	if q == nil {
+		return nil
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.file == nil {
+		return nil
+	}
+	close(q.done)
+	err := q.w.Flush()
+	if cerr := q.file.Close(); err == nil {
+		err = cerr
+	}
+	q.file = nil
+	return err
+}
+
+func (q *QueryLog) flushLoop() {
+	ticker := time.NewTicker(queryLogFlush)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.Lock()
+			if q.file != nil {
+				if err := q.w.Flush(); err != nil {
+					Error.Println(err)
+				}
+			}
+			q.Unlock()
+		case <-q.done:
+			return
+		}
+	}
+}
+
+func (q *QueryLog) open() error {
+	f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	q.file = f
+	q.w = bufio.NewWriter(f)
+	q.size = fi.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1 and reopens path
+func (q *QueryLog) rotate() error {
+	if err := q.w.Flush(); err != nil {
+		return err
+	}
+	if err := q.file.Close(); err != nil {
+		return err
+	}
+	q.file = nil
+
+	if q.maxBackups > 0 {
+		for i := q.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", q.path, i), fmt.Sprintf("%s.%d", q.path, i+1))
+		}
+		if err := os.Rename(q.path, q.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(q.path); err != nil {
+		return err
+	}
+
+	return q.open()
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	SetupLogs()
+}
+
+func TestQueryLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queries.log")
+	q, err := NewQueryLog(path, 1, 300, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		q.Log(QueryEntry{Client: "10.0.0.1", Proto: "udp", Name: "web.marathon.mesos.", Type: "A",
+			Rcode: "NOERROR", Answers: 1, Source: SourceMesos})
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(path + ".2"); err != nil {
+		t.Error("should keep two rotated files")
+	}
+	if _, err = os.Stat(path + ".3"); err == nil {
+		t.Error("should not keep more than two rotated files")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e QueryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Error(err)
+		}
+		if e.Name != "web.marathon.mesos." || e.Source != SourceMesos {
+			t.Errorf("unexpected entry %+v", e)
+		}
+		lines++
+	}
+	if lines == 0 {
+		t.Error("expected entries in the current file")
+	}
+}
+
+func TestQueryLogDisabled(t *testing.T) {
+	var q *QueryLog
+	if q.Sampled() {
+		t.Error("a nil query log should never sample")
+	}
+
+	if _, err := NewQueryLog(os.DevNull, 0, 0, 0); err == nil {
+		t.Error("should reject a zero sample rate")
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
@@ -16,20 +19,25 @@ import (
 )
 
 func main() {
-	var wg sync.WaitGroup
-	var resolver resolver.Resolver
-
 	versionFlag := false
 
 	cjson := flag.String("config", "config.json", "location of configuration file (json)")
 	flag.BoolVar(&versionFlag, "version", false, "output the version")
+	checkFlag := flag.Bool("check-config", false, "validate the configuration, print the effective configuration and exit")
+	layers := records.ConfigLayers{Env: os.Environ()}
+	layers.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	layers.File = configFile(*cjson)
 
 	if versionFlag {
 		fmt.Println(version)
 		os.Exit(0)
 	}
 
+	if *checkFlag {
+		os.Exit(checkConfig(layers))
+	}
+
 	if glog.V(2) {
 		logging.VeryVerboseFlag = true
 	} else if glog.V(1) {
@@ -38,18 +46,108 @@ func main() {
 
 	logging.SetupLogs()
 
-	resolver.Config = records.SetConfig(*cjson)
+	config := layers.MustLoad()
+	resolver := resolver.New(config)
 
-	// handle for everything in this domain...
-	dns.HandleFunc(resolver.Config.Domain+".", panicRecover(resolver.HandleMesos))
-	dns.HandleFunc(".", panicRecover(resolver.HandleNonMesos))
+	masters, err := records.NewMasterClient(config.MesosClient)
+	if err != nil {
+		logging.Error.Println(err)
+		os.Exit(1)
+	}
+	resolver.Masters = masters
 
-	go resolver.Serve("tcp")
-	go resolver.Serve("udp")
+	if ql := config.QueryLog; ql.File != "" {
+		qlog, err := logging.NewQueryLog(ql.File, ql.SampleRate, int64(ql.MaxSizeMB)<<20, ql.MaxBackups)
+		if err != nil {
+			logging.Error.Println("cannot open query log: ", err)
+			os.Exit(1)
+		}
+		resolver.QueryLog = qlog
+	}
+
+	if len(config.ZoneFiles) > 0 {
+		zone := records.NewZoneSource(config.ZoneFiles, config.Domain)
+		if _, err := zone.Records(); err != nil {
+			logging.Error.Println("invalid zone files: ", err)
+			os.Exit(1)
+		}
+		resolver.Sources = append(resolver.Sources, zone)
+	}
+	if len(config.Updates.Keys) > 0 {
+		store, err := records.NewUpdateStore(config.Updates.StoreFile)
+		if err != nil {
+			logging.Error.Println("cannot load updates: ", err)
+			os.Exit(1)
+		}
+		resolver.Updates = store
+		resolver.Sources = append(resolver.Sources, store)
+	}
+
+	// serve the last known records until the masters answer
+	warm := false
+	if config.SnapshotFile != "" {
+		if err := resolver.LoadSnapshot(); err != nil {
+			logging.Error.Println("not warm starting: ", err)
+		} else {
+			warm = true
+		}
+	}
+
+	resolver.RefreshSources()
+
+	// serve the sockets of the process restarting into this one
+	if err := resolver.Inherit(); err != nil {
+		logging.Error.Println(err)
+		os.Exit(1)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+
+	// every listener handles this domain and everything else, as far as
+	// its roles allow
+	var servers []*dns.Server
+	for _, l := range config.DNSListeners() {
+		mesos, nonMesos := resolver.ListenerHandlers(l)
+		mux := dns.NewServeMux()
+		mux.HandleFunc(config.Domain+".", panicRecover(mesos))
+		mux.HandleFunc(".", panicRecover(nonMesos))
+		for _, proto := range l.Protocols {
+			server, err := resolver.Listen(proto, l.Addr(), mux)
+			if err != nil {
+				logging.Error.Println(err)
+				os.Exit(1)
+			}
+			servers = append(servers, server)
+		}
+	}
+	var api net.Listener
+	if config.HTTPOn {
+		if api, err = resolver.ListenHTTP(); err != nil {
+			logging.Error.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// with every socket bound, privileges are no longer needed
+	if err := dropPrivileges(config.User, config.Group); err != nil {
+		logging.Error.Println("cannot drop privileges, refusing to start: ", err)
+		os.Exit(1)
+	}
+
+	for _, server := range servers {
+		go resolver.Serve(server)
+	}
+	if api != nil {
+		go resolver.LaunchHTTP(api)
+	}
+	if warm {
+		resolver.Ready()
+	}
 
 	// if ZK is identified, start detector and wait for first master
-	if resolver.Config.Zk != "" {
-		dr, err := records.ZKdetect(&resolver.Config)
+	if config.Zk != "" {
+		resolver.ZK = records.NewZKDetector()
+		dr, err := resolver.ZK.Start(config.Zk)
 		if err != nil {
 			logging.Error.Println(err.Error())
 			os.Exit(1)
@@ -60,23 +158,78 @@ func main() {
 		case <-dr:
 			logging.VeryVerbose.Println("Warning: done waiting for initial information from Zookeper.")
 		case <-time.After(2 * time.Minute):
-			logging.Error.Println("timed out waiting for initial ZK detection, exiting")
-			os.Exit(1)
+			if !warm {
+				logging.Error.Println("timed out waiting for initial ZK detection, exiting")
+				os.Exit(1)
+			}
+			logging.Error.Println("timed out waiting for initial ZK detection, serving snapshot")
 		}
 	}
 
 	// reload the first time
 	resolver.Reload()
-	ticker := time.NewTicker(time.Second * time.Duration(resolver.Config.RefreshSeconds))
-	go func() {
-		for _ = range ticker.C {
-			resolver.Reload()
-			logging.PrintCurLog()
+	resolver.Ready()
+	go resolver.Refresh()
+	go resolver.WatchConfig()
+	go resolver.WatchSources()
+
+	// SIGUSR2 restarts into a new process, taking over the sockets
+	for sig := range sigs {
+		if sig == syscall.SIGUSR2 {
+			logging.Verbose.Println("restarting")
+			if err := resolver.Restart(); err != nil {
+				logging.Error.Println("restart failed: ", err)
+				continue
+			}
 		}
-	}()
+		logging.Verbose.Println("shutting down on ", sig)
+		resolver.Shutdown()
+		os.Exit(0)
+	}
+}
+
+// configFile returns the configuration file to load: the one given with
+// -config, or else in the environment, or else config.json if there is one
+func configFile(cjson string) string {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == "config"
+	})
+	if set {
+		return cjson
+	}
+	if file, ok := os.LookupEnv(records.EnvConfig); ok {
+		return file
+	}
+	if _, err := os.Stat(cjson); err != nil {
+		return ""
+	}
+	return cjson
+}
+
+// checkConfig validates the configuration of the layers and prints the
+// configuration in effect, or every problem found. It returns the exit
+// status.
+func checkConfig(layers records.ConfigLayers) int {
+	config, err := layers.Load()
+	if errs, ok := err.(records.ConfigErrors); ok {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return 1
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
 
-	wg.Add(1)
-	wg.Wait()
+	// secrets are not printed
+	b, err := json.MarshalIndent(config.Redacted(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(string(b))
+	return 0
 }
 
 // panicRecover catches any panics from the resolvers and sets an error
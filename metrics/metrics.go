@@ -0,0 +1,337 @@
+// package metrics implements the counters, gauges and histograms exported
+// by mesos-dns in the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to
+// DNS response latencies
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Registry holds a set of metric families
+type Registry struct {
+	sync.Mutex
+	families []*family
+}
+
+// DefaultRegistry is the registry used by the package level constructors
+// and served by Handler
+var DefaultRegistry = &Registry{}
+
+// family is a named metric with its series, one per set of label values
+type family struct {
+	sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series is a single time series; counters and gauges only use value,
+// histograms use value as their sum
+type series struct {
+	labels []string
+	value  uint64
+	counts []uint64
+	count  uint64
+}
+
+func (r *Registry) register(f *family) *family {
+	r.Lock()
+	defer r.Unlock()
+	for _, g := range r.families {
+		if g.name == f.name {
+			panic("metrics: duplicate metric " + f.name)
+		}
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.Lock()
+	defer f.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// addFloat atomically adds v to the float64 stored in bits
+func addFloat(bits *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(bits, old, sum) {
+			return
+		}
+	}
+}
+
+func loadFloat(bits *uint64) float64 {
+	return math.Float64frombits(atomic.LoadUint64(bits))
+}
+
+// Counter is a monotonically increasing value
+type Counter struct{ s *series }
+
+// Inc increments the counter by one
+func (c *Counter) Inc() { addFloat(&c.s.value, 1) }
+
+// Add increments the counter by v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters can't decrease")
+	}
+	addFloat(&c.s.value, v)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() float64 { return loadFloat(&c.s.value) }
+
+// Gauge is a value that can go up and down
+type Gauge struct{ s *series }
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) { atomic.StoreUint64(&g.s.value, math.Float64bits(v)) }
+
+// Add adds v to the gauge
+func (g *Gauge) Add(v float64) { addFloat(&g.s.value, v) }
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 { return loadFloat(&g.s.value) }
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// Observe adds a single observation to the histogram
+func (h *Histogram) Observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		atomic.AddUint64(&h.s.counts[i], 1)
+	}
+	atomic.AddUint64(&h.s.count, 1)
+	addFloat(&h.s.value, v)
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct{ f *family }
+
+// With returns the counter for the given label values
+func (v *CounterVec) With(values ...string) *Counter { return &Counter{v.f.with(values)} }
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct{ f *family }
+
+// With returns the gauge for the given label values
+func (v *GaugeVec) With(values ...string) *Gauge { return &Gauge{v.f.with(values)} }
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct{ f *family }
+
+// With returns the histogram for the given label values
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{v.f.with(values), v.f.buckets}
+}
+
+func (r *Registry) newFamily(kind, name, help string, buckets []float64, labels []string) *family {
+	return r.register(&family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	})
+}
+
+// NewCounterVec registers a counter partitioned by labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.newFamily("counter", name, help, nil, labels)}
+}
+
+// NewGaugeVec registers a gauge partitioned by labels
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.newFamily("gauge", name, help, nil, labels)}
+}
+
+// NewHistogramVec registers a histogram partitioned by labels; buckets
+// are the sorted upper bounds of the buckets
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.newFamily("histogram", name, help, buckets, labels)}
+}
+
+// NewCounterVec registers a counter partitioned by labels with the
+// DefaultRegistry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// NewGaugeVec registers a gauge partitioned by labels with the
+// DefaultRegistry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// NewHistogramVec registers a histogram partitioned by labels with the
+// DefaultRegistry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewCounter registers an unlabelled counter with the DefaultRegistry
+func NewCounter(name, help string) *Counter { return NewCounterVec(name, help).With() }
+
+// NewGauge registers an unlabelled gauge with the DefaultRegistry
+func NewGauge(name, help string) *Gauge { return NewGaugeVec(name, help).With() }
+
+// NewHistogram registers an unlabelled histogram with the DefaultRegistry
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return NewHistogramVec(name, help, buckets).With()
+}
+
+// WriteTo writes all metrics of the registry in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.Lock()
+	families := append([]*family(nil), r.families...)
+	r.Unlock()
+	sort.Sort(byName(families))
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (f *family) write(w *countingWriter) {
+	f.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.Unlock()
+	sort.Sort(byLabels(all))
+
+	w.printf("# HELP %s %s\n", f.name, escape(f.help, false))
+	w.printf("# TYPE %s %s\n", f.name, f.kind)
+
+	for _, s := range all {
+		if f.kind != "histogram" {
+			w.printf("%s%s %s\n", f.name, labelPairs(f.labels, s.labels, "", ""), formatFloat(loadFloat(&s.value)))
+			continue
+		}
+
+		var cum uint64
+		for i, b := range f.buckets {
+			cum += atomic.LoadUint64(&s.counts[i])
+			w.printf("%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.labels, "le", formatFloat(b)), cum)
+		}
+		count := atomic.LoadUint64(&s.count)
+		w.printf("%s_bucket%s %d\n", f.name, labelPairs(f.labels, s.labels, "le", "+Inf"), count)
+		w.printf("%s_sum%s %s\n", f.name, labelPairs(f.labels, s.labels, "", ""), formatFloat(loadFloat(&s.value)))
+		w.printf("%s_count%s %d\n", f.name, labelPairs(f.labels, s.labels, "", ""), count)
+	}
+}
+
+// labelPairs formats label names and values, plus an optional extra pair
+func labelPairs(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		pairs = append(pairs, n+`="`+escape(values[i], true)+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escape escapes backslashes and newlines, and double quotes in label values
+func escape(s string, quotes bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+type byName []*family
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].name < s[j].name }
+
+type byLabels []*series
+
+func (s byLabels) Len() int      { return len(s) }
+func (s byLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byLabels) Less(i, j int) bool {
+	return strings.Join(s[i].labels, "\xff") < strings.Join(s[j].labels, "\xff")
+}
+
+// Handler serves the metrics of the DefaultRegistry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = DefaultRegistry.WriteTo(w)
+	})
+}
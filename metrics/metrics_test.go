@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := &Registry{}
+
+	queries := r.NewCounterVec("dns_queries_total", "Queries.", "qtype", "rcode")
+	queries.With("A", "NOERROR").Inc()
+	queries.With("A", "NOERROR").Inc()
+	queries.With("SRV", "NXDOMAIN").Add(3)
+
+	r.NewGaugeVec("dns_records", "Records by \"type\".", "type").With(`quo"te`).Set(4)
+
+	latency := r.NewHistogramVec("dns_latency_seconds", "Latency.", []float64{.1, 1})
+	latency.With().Observe(.05)
+	latency.With().Observe(.5)
+	latency.With().Observe(5)
+
+	var b bytes.Buffer
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`# TYPE dns_latency_seconds histogram`,
+		`dns_latency_seconds_bucket{le="0.1"} 1`,
+		`dns_latency_seconds_bucket{le="1"} 2`,
+		`dns_latency_seconds_bucket{le="+Inf"} 3`,
+		`dns_latency_seconds_sum 5.55`,
+		`dns_latency_seconds_count 3`,
+		`# TYPE dns_queries_total counter`,
+		`dns_queries_total{qtype="A",rcode="NOERROR"} 2`,
+		`dns_queries_total{qtype="SRV",rcode="NXDOMAIN"} 3`,
+		`# HELP dns_records Records by "type".`,
+		`dns_records{type="quo\"te"} 4`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+
+	if strings.Index(b.String(), "dns_latency_seconds") > strings.Index(b.String(), "dns_queries_total") {
+		t.Error("metric families should be sorted by name")
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	r := &Registry{}
+	r.NewCounterVec("dup_total", "Duplicate.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("should not register the same metric twice")
+		}
+	}()
+	r.NewGaugeVec("dup_total", "Duplicate.")
+}
@@ -0,0 +1,78 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges switches the process to username and group, by name or
+// id. The group defaults to the primary group of the user, whose
+// supplementary groups are kept. A process running as them already, like
+// one restarted by a process that dropped privileges, is left as it is.
+func dropPrivileges(username, group string) error {
+	if username == "" && group == "" {
+		return nil
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	groups := []int{}
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			if u, err = user.LookupId(username); err != nil {
+				return fmt.Errorf("unknown user %s", username)
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return fmt.Errorf("user %s: invalid uid %s", username, u.Uid)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return fmt.Errorf("user %s: invalid gid %s", username, u.Gid)
+		}
+		if ids, err := u.GroupIds(); err == nil && group == "" {
+			for _, id := range ids {
+				if n, err := strconv.Atoi(id); err == nil {
+					groups = append(groups, n)
+				}
+			}
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return fmt.Errorf("unknown group %s", group)
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return fmt.Errorf("group %s: invalid gid %s", group, g.Gid)
+		}
+	}
+	if len(groups) == 0 {
+		groups = []int{gid}
+	}
+
+	if uid == os.Getuid() && gid == os.Getgid() {
+		return nil
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("cannot set supplementary groups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("cannot set group %d: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("cannot set user %d: %v", uid, err)
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return errors.New("root privileges could be regained")
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package main
+
+import "errors"
+
+// dropPrivileges fails unless there is nothing to drop: windows has no
+// user and group to switch to
+func dropPrivileges(username, group string) error {
+	if username == "" && group == "" {
+		return nil
+	}
+	return errors.New("switching user and group is not supported on windows")
+}
@@ -1,20 +1,17 @@
 package records
 
 import (
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/user"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
-	"sync"
-
-	"github.com/mesos/mesos-go/detector"
-	_ "github.com/mesos/mesos-go/detector/zoo"
-	mesos "github.com/mesos/mesos-go/mesosproto"
 
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/miekg/dns"
@@ -29,9 +26,36 @@ type Config struct {
 	// Zookeeper: a single Zk url
 	Zk string
 
+	// MesosClient configures how the HTTP API of the masters is accessed
+	MesosClient MesosClientConfig
+
 	// Refresh frequency: the frequency in seconds of regenerating records (default 60)
 	RefreshSeconds int
 
+	// Refresh configures what triggers a refresh besides the timer
+	Refresh RefreshConfig
+
+	// Stale configures how records are served once refreshes keep failing
+	Stale StaleConfig
+
+	// Sources configures how the records of several sources are merged
+	Sources SourcesConfig
+
+	// ZoneFiles are zone files in RFC 1035 master format whose A, SRV and
+	// CNAME records are served along with those of the tasks
+	ZoneFiles []string
+
+	// Updates configures the records added and deleted by signed DNS
+	// UPDATE messages
+	Updates UpdatesConfig
+
+	// Health configures the health and readiness checks
+	Health HealthConfig
+
+	// SnapshotFile persists every generation of records, which is served
+	// on startup until the first refresh succeeds; empty disables it
+	SnapshotFile string
+
 	// TTL: the TTL value used for SRV and A records (default 60)
 	TTL int
 
@@ -51,6 +75,15 @@ type Config struct {
 	// File is the location of the config.json file
 	File string
 
+	// ShutdownSeconds is how long queries in flight are waited for on
+	// shutdown (default 10)
+	ShutdownSeconds int
+
+	// ConfigPollSeconds is how often the configuration file is checked
+	// for changes, which are then applied like on SIGHUP; 0 disables
+	// polling (default 5)
+	ConfigPollSeconds int
+
 	// Email is the rname for a SOA
 	Email string
 
@@ -60,54 +93,455 @@ type Config struct {
 	// ListenAddr is the server listener address
 	Listener string
 
-	// Leading master info, as identified through Zookeeper
-	leader     string
-	leaderLock sync.RWMutex
+	// Listeners are the addresses DNS is served on, each with its own
+	// protocols and roles (default Listener and Port, with every protocol
+	// and role)
+	Listeners []ListenerConfig
+
+	// User and Group are who the process runs as once the listeners are
+	// bound; empty keeps the user and group it was started with. Group
+	// defaults to the primary group of User.
+	User  string
+	Group string
+
+	// HTTPOn enables the HTTP API (default true)
+	HTTPOn bool
+
+	// HTTPPort is the port of the HTTP API (default 8123)
+	HTTPPort int
+
+	// QueryLog configures the optional log of answered queries
+	QueryLog QueryLogConfig
+
+	// ACL restricts each capability to a set of client networks
+	ACL ACLConfig
+
+	// RateLimit throttles queries and HTTP requests per client prefix
+	RateLimit RateLimitConfig
+
+	// Views are split-horizon views, the first one matching a client
+	// answers its queries; other clients get the default view
+	Views []ViewConfig
+
+	// ECSForwarders lists the forwarders trusted to pass the client
+	// address in an EDNS Client Subnet option, which then selects the view
+	ECSForwarders []string
+
+	// layers the configuration was loaded from
+	layers ConfigLayers
 }
 
-// SetConfig instantiates a Config struct read in from config.json
-func SetConfig(cjson string) (c Config) {
-	c = Config{
-		Zk:             "",
-		RefreshSeconds: 60,
-		TTL:            60,
-		Domain:         "mesos",
-		Port:           53,
-		Timeout:        5,
-		Email:          "root.mesos-dns.mesos",
-		Resolvers:      []string{"8.8.8.8"},
-		Listener:       "0.0.0.0",
-		leader:         "",
+// roles of a listener
+const (
+	RoleAuthoritative = "authoritative"
+	RoleRecursion     = "recursion"
+)
+
+// ListenerConfig holds the settings of a DNS listener
+type ListenerConfig struct {
+	// Address is the IP address listened on (default Listener)
+	Address string
+
+	// Port is the port listened on (default Port)
+	Port int
+
+	// Protocols are the protocols served, "udp" and "tcp" (default both)
+	Protocols []string
+
+	// Roles are what the listener answers: queries for the domain
+	// ("authoritative") and queries forwarded to the resolvers
+	// ("recursion") (default both); other queries are refused
+	Roles []string
+}
+
+// Addr returns the address and port of the listener
+func (l ListenerConfig) Addr() string {
+	return net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+}
+
+// HasRole tells whether the listener answers queries of role
+func (l ListenerConfig) HasRole(role string) bool {
+	for _, r := range l.Roles {
+		if r == role {
+			return true
+		}
 	}
+	return false
+}
 
-	usr, _ := user.Current()
-	dir := usr.HomeDir + "/"
-	cjson = strings.Replace(cjson, "~/", dir, 1)
+// DNSListeners returns the listeners DNS is served on, with their unset
+// fields defaulted
+func (c Config) DNSListeners() []ListenerConfig {
+	ls := c.Listeners
+	if len(ls) == 0 {
+		ls = []ListenerConfig{{}}
+	}
+	out := make([]ListenerConfig, len(ls))
+	for i, l := range ls {
+		if l.Address == "" {
+			l.Address = c.Listener
+		}
+		if l.Port == 0 {
+			l.Port = c.Port
+		}
+		if len(l.Protocols) == 0 {
+			l.Protocols = []string{"udp", "tcp"}
+		}
+		if len(l.Roles) == 0 {
+			l.Roles = []string{RoleAuthoritative, RoleRecursion}
+		}
+		out[i] = l
+	}
+	return out
+}
 
-	path, err := filepath.Abs(cjson)
-	if err != nil {
-		logging.Error.Println("cannot find configuration file")
-		os.Exit(1)
+// authoritativeAddresses returns the addresses of the listeners answering
+// for the domain, which the mname record points to
+func (c Config) authoritativeAddresses() []string {
+	var addrs []string
+	for _, l := range c.DNSListeners() {
+		if l.HasRole(RoleAuthoritative) {
+			addrs = append(addrs, l.Address)
+		}
 	}
+	return addrs
+}
 
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		logging.Error.Println("missing configuration file")
-		os.Exit(1)
+// MesosClientConfig holds the settings of the client for Mesos masters
+type MesosClientConfig struct {
+	// HTTPS makes masters be accessed over https
+	HTTPS bool
+
+	// CACertFile is a PEM file of the CAs masters' certificates are
+	// verified with (default the system CAs)
+	CACertFile string
+
+	// CertFile and KeyFile are a PEM client certificate and key presented
+	// to the masters
+	CertFile string
+	KeyFile  string
+
+	// Username and Password are sent with HTTP basic authentication
+	Username string
+	Password string
+
+	// Token is sent as a bearer token, instead of basic authentication
+	Token string
+
+	// ConnectTimeout is the timeout in seconds to connect to a master (default 5)
+	ConnectTimeout int
+
+	// ReadTimeout is the timeout in seconds to wait for the headers of a
+	// response, then for every read of its body (default 30)
+	ReadTimeout int
+
+	// Retries is the number of times a failed request is retried (default 2)
+	Retries int
+
+	// RetryBackoffMillis is the delay before the first retry, doubling
+	// with every further retry (default 500)
+	RetryBackoffMillis int
+}
+
+// RefreshConfig holds the triggers of immediate refreshes. Triggers are
+// debounced, and refreshes never run closer than the minimum interval.
+type RefreshConfig struct {
+	// LeaderChange refreshes when Zookeeper reports a new leader (default true)
+	LeaderChange bool
+
+	// HTTP enables POST /v1/reload (default true)
+	HTTP bool
+
+	// Signal refreshes on SIGUSR1 (default true)
+	Signal bool
+
+	// DebounceMillis is how long triggers are collected before a refresh (default 500)
+	DebounceMillis int
+
+	// MinIntervalSeconds is the minimum time between two refreshes (default 5)
+	MinIntervalSeconds int
+}
+
+// policies for answering with stale records
+const (
+	StaleServe    = "serve"
+	StaleTTL      = "ttl"
+	StaleServfail = "servfail"
+)
+
+// StaleConfig holds what happens to the records once refreshes have been
+// failing for too long
+type StaleConfig struct {
+	// MaxSeconds is the age after which records are stale; 0 never (default 0)
+	MaxSeconds int
+
+	// Policy answers stale records as usual ("serve"), with TTLs lowered
+	// to FloorTTL ("ttl") or with SERVFAIL ("servfail") (default "serve")
+	Policy string
+
+	// FloorTTL is the TTL of stale answers under the "ttl" policy (default 5)
+	FloorTTL int
+
+	// StatusRecord publishes the refresh status as TXT record _status.<domain>
+	StatusRecord bool
+}
+
+// SourcesConfig holds how the records of several sources are merged
+type SourcesConfig struct {
+	// Precedence lists sources by precedence, highest first; unlisted
+	// sources follow in their default order (default ["zone", "mesos",
+	// "updates"])
+	Precedence []string
+
+	// Conflicts is how names several sources have records for are served:
+	// by the source of highest precedence only ("precedence") or by every
+	// source ("merge") (default "precedence")
+	Conflicts string
+}
+
+// UpdatesConfig holds the settings of dynamic updates (RFC 2136)
+type UpdatesConfig struct {
+	// Keys are the TSIG keys updates must be signed with; none disables
+	// updates
+	Keys []TSIGKey
+
+	// LeaseSeconds is how long added records are served unless added
+	// again, and the longest lease clients can ask for (default 3600)
+	LeaseSeconds int
+
+	// StoreFile persists the added records and their leases; empty keeps
+	// them in memory only
+	StoreFile string
+}
+
+// TSIGKey is a secret shared with the clients sending updates
+type TSIGKey struct {
+	// Name is the name of the key, like update.mesos.
+	Name string
+
+	// Algorithm is "hmac-md5", "hmac-sha1" or "hmac-sha256" (default
+	// "hmac-sha256")
+	Algorithm string
+
+	// Secret is the base64 encoded secret
+	Secret string
+}
+
+// tsigAlgorithms are the names of the supported TSIG algorithms
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":    dns.HmacMD5,
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha256": dns.HmacSHA256,
+}
+
+// Secrets returns the secrets of the keys by fully qualified key name, nil
+// without keys
+func (u UpdatesConfig) Secrets() map[string]string {
+	if len(u.Keys) == 0 {
+		return nil
+	}
+	// the server looks secrets up by the name as sent, which clients
+	// may spell as configured or in lower case
+	secrets := make(map[string]string, 2*len(u.Keys))
+	for _, k := range u.Keys {
+		secrets[dns.Fqdn(k.Name)] = k.Secret
+		secrets[tsigName(k.Name)] = k.Secret
 	}
+	return secrets
+}
+
+// tsigName returns the fully qualified key name in lower case, as keys
+// are compared
+func tsigName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// Algorithm returns the TSIG algorithm of the key name as written in
+// messages, empty for unknown keys
+func (u UpdatesConfig) Algorithm(name string) string {
+	for _, k := range u.Keys {
+		if tsigName(k.Name) == tsigName(name) {
+			if k.Algorithm == "" {
+				return dns.HmacSHA256
+			}
+			return tsigAlgorithms[k.Algorithm]
+		}
+	}
+	return ""
+}
+
+// HealthConfig holds the settings of the health and readiness checks
+type HealthConfig struct {
+	// MaxAgeSeconds is the age of the records beyond which mesos-dns isn't
+	// ready; 0 is three times RefreshSeconds (default 0)
+	MaxAgeSeconds int
+
+	// Record publishes the readiness as TXT record _health.<domain> (default true)
+	Record bool
+}
+
+// QueryLogConfig holds the settings of the query log
+type QueryLogConfig struct {
+	// File is where queries are logged as JSON lines; empty disables the log
+	File string
+
+	// SampleRate is the fraction of queries that are logged (default 1)
+	SampleRate float64
+
+	// MaxSizeMB is the size in megabytes at which the file is rotated (default 100)
+	MaxSizeMB int
+
+	// MaxBackups is the number of rotated files kept (default 5)
+	MaxBackups int
+}
+
+// ACLConfig holds the client networks (CIDRs or single addresses) allowed
+// to use each capability. A nil list allows every client, an empty list
+// allows none.
+type ACLConfig struct {
+	// Mesos lists the clients allowed to query the mesos domain
+	Mesos []string
+
+	// Recursion lists the clients allowed to have queries forwarded to
+	// the external resolvers
+	Recursion []string
+
+	// Transfer lists the clients allowed to transfer the mesos zone
+	// (default loopback only)
+	Transfer []string
+
+	// HTTP lists the clients allowed to use the HTTP API
+	HTTP []string
+}
 
-	err = json.Unmarshal(b, &c)
+// RateLimitConfig holds the rate limits applied to clients, which are
+// grouped by prefix. A zero rate disables the corresponding limit.
+type RateLimitConfig struct {
+	// QPS is the rate of queries per second allowed per client prefix
+	QPS float64
+
+	// Burst is the number of queries a client prefix may send at once (default QPS)
+	Burst int
+
+	// ResponsesPerSecond is the rate of identical UDP responses per
+	// second sent to a client prefix (response rate limiting)
+	ResponsesPerSecond float64
+
+	// ResponsesBurst is the number of identical responses a client prefix
+	// may get at once (default ResponsesPerSecond)
+	ResponsesBurst int
+
+	// Slip makes every Slip-th dropped UDP response a truncated one, so
+	// that legitimate clients retry over TCP; 0 never slips (default 2)
+	Slip int
+
+	// IPv4PrefixLen and IPv6PrefixLen group clients into prefixes
+	// (default 24 and 56)
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	// HTTPQPS is the rate of HTTP API requests per second allowed per
+	// client prefix
+	HTTPQPS float64
+
+	// HTTPBurst is the number of HTTP requests a client prefix may send at
+	// once (default HTTPQPS)
+	HTTPBurst int
+
+	// Exempt lists the client networks that are never rate limited
+	Exempt []string
+}
+
+// ViewConfig holds the settings of a split-horizon view
+type ViewConfig struct {
+	// Name identifies the view
+	Name string
+
+	// Clients lists the client networks the view answers
+	Clients []string
+
+	// AddressPolicy chooses the address of slaves in A records: "hostname"
+	// (default), "ip" or "attribute:<name>"
+	AddressPolicy string
+
+	// Resolvers are the DNS servers queries outside the domain are
+	// forwarded to (default the global Resolvers)
+	Resolvers []string
+}
+
+// ParseCIDR parses a network in CIDR notation, or a single IP address as
+// a network of just that address
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid network address %q", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(s)
 	if err != nil {
+		return nil, fmt.Errorf("invalid network address %q", s)
+	}
+	return ipnet, nil
+}
+
+// SetConfig instantiates a Config struct read in from config.json,
+// exiting on invalid configurations
+func SetConfig(cjson string) Config {
+	return ConfigLayers{File: cjson}.MustLoad()
+}
+
+// LoadConfig reads and validates the configuration in the file cjson
+func LoadConfig(cjson string) (Config, error) {
+	return ConfigLayers{File: cjson}.Load()
+}
+
+// MustLoad loads the configuration of the layers, exiting on invalid
+// configurations
+func (l ConfigLayers) MustLoad() Config {
+	c, err := l.Load()
+	if errs, ok := err.(ConfigErrors); ok {
+		for _, e := range errs {
+			logging.Error.Println(e)
+		}
+		os.Exit(1)
+	} else if err != nil {
 		logging.Error.Println(err)
+		os.Exit(1)
 	}
+	c.log()
+	return c
+}
+
+// Load reads and validates the configuration of the layers
+func (l ConfigLayers) Load() (Config, error) {
+	c := defaultConfig()
+	var errs ConfigErrors
+	if l.File != "" {
+		var err error
+		if errs, err = c.readFile(l.File); err != nil {
+			return c, err
+		}
+	}
+	errs = append(errs, l.apply(&c)...)
+	c.layers = l
 
 	if len(c.Resolvers) == 0 {
-		c.Resolvers = GetLocalDNS()
+		var err error
+		if c.Resolvers, err = localDNS(); err != nil {
+			errs.add("resolvers", "%v", err)
+		}
 	}
 
-	if len(c.Masters) == 0 && c.Zk == "" {
-		logging.Error.Println("specify mesos masters or zookeeper in config.json")
-		os.Exit(1)
+	errs = append(errs, c.validate()...)
+	if len(errs) > 0 {
+		return c, errs
 	}
 
 	c.Email = strings.Replace(c.Email, "@", ".", -1)
@@ -118,6 +552,140 @@ func SetConfig(cjson string) (c Config) {
 	c.Domain = strings.ToLower(c.Domain)
 	c.Mname = "mesos-dns." + c.Domain + "."
 
+	return c, nil
+}
+
+// Reread loads the configuration again from the layers it was loaded from
+func (c Config) Reread() (Config, error) {
+	return c.layers.Load()
+}
+
+// defaultConfig returns the configuration used for unset fields
+func defaultConfig() Config {
+	return Config{
+		Zk:                "",
+		RefreshSeconds:    60,
+		ConfigPollSeconds: 5,
+		ShutdownSeconds:   10,
+		Refresh: RefreshConfig{
+			LeaderChange:       true,
+			HTTP:               true,
+			Signal:             true,
+			DebounceMillis:     500,
+			MinIntervalSeconds: 5,
+		},
+		Stale: StaleConfig{
+			Policy:   StaleServe,
+			FloorTTL: 5,
+		},
+		Sources: SourcesConfig{
+			Conflicts: ConflictPrecedence,
+		},
+		Updates: UpdatesConfig{
+			LeaseSeconds: 3600,
+		},
+		Health: HealthConfig{
+			Record: true,
+		},
+		TTL:       60,
+		Domain:    "mesos",
+		Port:      53,
+		Timeout:   5,
+		Email:     "root.mesos-dns.mesos",
+		Resolvers: []string{"8.8.8.8"},
+		Listener:  "0.0.0.0",
+		HTTPOn:    true,
+		HTTPPort:  8123,
+		MesosClient: MesosClientConfig{
+			ConnectTimeout:     5,
+			ReadTimeout:        30,
+			Retries:            2,
+			RetryBackoffMillis: 500,
+		},
+		QueryLog: QueryLogConfig{
+			SampleRate: 1,
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
+		ACL: ACLConfig{
+			Transfer: []string{"127.0.0.1", "::1"},
+		},
+		RateLimit: RateLimitConfig{
+			Slip:          2,
+			IPv4PrefixLen: 24,
+			IPv6PrefixLen: 56,
+		},
+	}
+}
+
+// readFile reads the json file cjson into c. Problems with the fields are
+// returned as configuration errors, others as error.
+func (c *Config) readFile(cjson string) (ConfigErrors, error) {
+	if usr, err := user.Current(); err == nil {
+		cjson = strings.Replace(cjson, "~/", usr.HomeDir+"/", 1)
+	}
+
+	path, err := filepath.Abs(cjson)
+	if err != nil {
+		return nil, errors.New("cannot find configuration file")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("missing configuration file")
+	}
+
+	// type errors are collected with the others, syntax errors end it
+	var errs ConfigErrors
+	if err = json.Unmarshal(b, c); err != nil {
+		te, ok := err.(*json.UnmarshalTypeError)
+		if !ok {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		errs.add(te.Field, "cannot decode %s into %s", te.Value, te.Type)
+	}
+	c.File = path
+
+	for _, key := range unknownKeys(b, reflect.TypeOf(*c), "") {
+		errs.add(key, "unknown key")
+	}
+
+	return errs, nil
+}
+
+// Redacted returns the configuration without its secrets, fit to be
+// printed: passwords, tokens, TSIG secrets and the credentials of master
+// addresses
+func (c Config) Redacted() Config {
+	if c.MesosClient.Password != "" {
+		c.MesosClient.Password = redacted
+	}
+	if c.MesosClient.Token != "" {
+		c.MesosClient.Token = redacted
+	}
+	keys := make([]TSIGKey, len(c.Updates.Keys))
+	for i, k := range c.Updates.Keys {
+		if k.Secret != "" {
+			k.Secret = redacted
+		}
+		keys[i] = k
+	}
+	c.Updates.Keys = keys
+	masters := make([]string, len(c.Masters))
+	for i, m := range c.Masters {
+		masters[i] = redactAddress(m)
+	}
+	c.Masters = masters
+	c.Zk = redactAddress(c.Zk)
+	return c
+}
+
+// redacted stands for the secrets of a redacted configuration
+const redacted = "<redacted>"
+
+// log logs the configuration
+func (c Config) log() {
+	c = c.Redacted()
 	logging.Verbose.Println("Mesos-DNS configuration:")
 	if len(c.Masters) != 0 {
 		logging.Verbose.Println("   - Masters: " + strings.Join(c.Masters, ", "))
@@ -125,17 +693,85 @@ func SetConfig(cjson string) (c Config) {
 	if c.Zk != "" {
 		logging.Verbose.Println("   - Zookeeper: ", c.Zk)
 	}
+	if c.MesosClient.HTTPS {
+		logging.Verbose.Println("   - Mesos masters over https")
+	}
 	logging.Verbose.Println("   - RefreshSeconds: ", c.RefreshSeconds)
+	logging.Verbose.Printf("   - Refresh triggers: leader change %v, http %v, signal %v (min interval %ds)\n",
+		c.Refresh.LeaderChange, c.Refresh.HTTP, c.Refresh.Signal, c.Refresh.MinIntervalSeconds)
 	logging.Verbose.Println("   - TTL: ", c.TTL)
+	if c.Stale.MaxSeconds > 0 {
+		logging.Verbose.Printf("   - Stale after %ds: %s\n", c.Stale.MaxSeconds, c.Stale.Policy)
+	}
 	logging.Verbose.Println("   - Domain: " + c.Domain)
 	logging.Verbose.Println("   - Port: ", c.Port)
 	logging.Verbose.Println("   - Timeout: ", c.Timeout)
 	logging.Verbose.Println("   - Listener: " + c.Listener)
+	for _, l := range c.DNSListeners() {
+		logging.Verbose.Printf("   - DNS listener %s: %s, %s\n", l.Addr(),
+			strings.Join(l.Protocols, "/"), strings.Join(l.Roles, ", "))
+	}
+	if c.User != "" || c.Group != "" {
+		logging.Verbose.Printf("   - Runs as user %q, group %q\n", c.User, c.Group)
+	}
+	logging.Verbose.Println("   - HTTPOn: ", c.HTTPOn)
+	logging.Verbose.Println("   - HTTPPort: ", c.HTTPPort)
+	logging.Verbose.Println("   - ConfigPollSeconds: ", c.ConfigPollSeconds)
+	logging.Verbose.Println("   - ShutdownSeconds: ", c.ShutdownSeconds)
+	if len(c.ZoneFiles) != 0 {
+		logging.Verbose.Println("   - ZoneFiles: " + strings.Join(c.ZoneFiles, ", "))
+	}
+	if len(c.Updates.Keys) != 0 {
+		logging.Verbose.Printf("   - Updates: %d keys, lease %ds, store %q\n",
+			len(c.Updates.Keys), c.Updates.LeaseSeconds, c.Updates.StoreFile)
+	}
+	if c.SnapshotFile != "" {
+		logging.Verbose.Println("   - SnapshotFile: " + c.SnapshotFile)
+	}
+	if c.QueryLog.File != "" {
+		logging.Verbose.Printf("   - QueryLog: %s (sample rate %v)\n", c.QueryLog.File, c.QueryLog.SampleRate)
+	}
 	logging.Verbose.Println("   - Resolvers: " + strings.Join(c.Resolvers, ", "))
+	for _, v := range c.Views {
+		logging.Verbose.Printf("   - View %s: clients %s, address policy %q\n", v.Name, strings.Join(v.Clients, ", "), v.AddressPolicy)
+	}
 	logging.Verbose.Println("   - Email: " + c.Email)
 	logging.Verbose.Println("   - Mname: " + c.Mname)
+}
 
-	return c
+// reloadable are the settings that take effect without a restart
+var reloadable = map[string]bool{
+	"Masters":         true,
+	"RefreshSeconds":  true,
+	"Stale":           true,
+	"Health":          true,
+	"Sources":         true,
+	"TTL":             true,
+	"Resolvers":       true,
+	"Timeout":         true,
+	"Email":           true,
+	"ACL":             true,
+	"ShutdownSeconds": true,
+}
+
+// Reload returns the configuration to run with once next is loaded: the
+// reloadable settings of next and the others of c. The settings of next
+// that differ from c but only take effect on a restart are returned too.
+func (c Config) Reload(next Config) (Config, []string) {
+	var restart []string
+	cur, nv := reflect.ValueOf(&c).Elem(), reflect.ValueOf(next)
+	for i := 0; i < cur.NumField(); i++ {
+		name := cur.Type().Field(i).Name
+		switch {
+		case cur.Type().Field(i).PkgPath != "":
+		case reloadable[name]:
+			cur.Field(i).Set(nv.Field(i))
+		case name == "File":
+		case !reflect.DeepEqual(cur.Field(i).Interface(), nv.Field(i).Interface()):
+			restart = append(restart, name)
+		}
+	}
+	return c, restart
 }
 
 // localAddies returns an array of local ipv4 addresses
@@ -186,60 +822,66 @@ func nonLocalAddies(cservers []string) []string {
 // GetLocalDNS returns the first nameserver in /etc/resolv.conf
 // used for out of mesos domain queries
 func GetLocalDNS() []string {
-	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	servers, err := localDNS()
 	if err != nil {
 		logging.Error.Println(err)
 		os.Exit(2)
 	}
 
-	return nonLocalAddies(conf.Servers)
+	return servers
 }
 
-// Start a Zookeeper listener to track leading master, returns a signal chan
-// that closes upon the first leader detection notification (and c.leader is
-// meaningfully readable).
-func ZKdetect(c *Config) (<-chan struct{}, error) {
-
-	// start listener
-	logging.Verbose.Println("Starting master detector for ZK ", c.Zk)
-	md, err := detector.New(c.Zk)
+// localDNS returns the non-local nameservers in /etc/resolv.conf
+func localDNS() ([]string, error) {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create master detector: %v", err)
-	}
-
-	// and listen for master changes
-	var startedOnce sync.Once
-	started := make(chan struct{})
-	if err := md.Detect(detector.OnMasterChanged(func(info *mesos.MasterInfo) {
-		// making this atomic
-		c.leaderLock.Lock()
-		defer c.leaderLock.Unlock()
-		logging.VeryVerbose.Println("Updated Zookeeper info: ", info)
-		if info == nil {
-			c.leader = ""
-			logging.Error.Println("No leader available in Zookeeper.")
-		} else if host := info.GetHostname(); host != "" {
-			c.leader = host
-		} else {
-			// unpack IPv4
-			octets := make([]byte, 4, 4)
-			binary.BigEndian.PutUint32(octets, info.GetIp())
-			ipv4 := net.IP(octets)
-			c.leader = ipv4.String()
-		}
-		if len(c.leader) > 0 {
-			c.leader = fmt.Sprintf("%s:%d", c.leader, info.GetPort())
-		}
-		logging.Verbose.Println("New master in Zookeeper ", c.leader)
-		startedOnce.Do(func() { close(started) })
-	})); err != nil {
-		return nil, fmt.Errorf("failed to initialize master detector: %v", err)
-	}
-	return started, nil
-}
-
-func (c *Config) getLeader() string {
-	c.leaderLock.Lock()
-	defer c.leaderLock.Unlock()
-	return c.leader
+		return nil, err
+	}
+	return nonLocalAddies(conf.Servers), nil
+}
+
+// checkMasters validates the master addresses and the zk field. A zk
+// address found in the masters list, directly or in a master file, is
+// used as the zk field if that is empty.
+func (c *Config) checkMasters() ConfigErrors {
+	var errs ConfigErrors
+	if c.Zk != "" {
+		a, err := ParseMasterAddress(c.Zk)
+		if err != nil {
+			errs.add("zk", "%v", err)
+		} else if a.Scheme != SchemeZk && a.Scheme != SchemeFile {
+			errs.add("zk", "%s is not a zk:// or file:// address", c.Zk)
+		}
+	}
+
+	for _, m := range c.Masters {
+		a, err := ParseMasterAddress(m)
+		if err != nil {
+			errs.add("masters", "%v", err)
+			continue
+		}
+		as := []MasterAddress{a}
+		if a.Scheme == SchemeFile {
+			if as, err = readMasterFile(a.Path); err != nil {
+				errs.add("masters", "%v", err)
+				continue
+			}
+		}
+
+		for _, a := range as {
+			switch a.Scheme {
+			case SchemeZk:
+				if c.Zk != "" && c.Zk != a.String() {
+					errs.add("masters", "%s conflicts with zk %s", a, c.Zk)
+					continue
+				}
+				c.Zk = a.String()
+			case SchemeHTTP, SchemeHTTPS:
+				if (a.Scheme == SchemeHTTPS) != c.MesosClient.HTTPS {
+					errs.add("masters", "the scheme of %s doesn't match mesosClient.https", m)
+				}
+			}
+		}
+	}
+	return errs
 }
@@ -1,6 +1,11 @@
 package records
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -14,3 +19,121 @@ func TestNonLocalAddies(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCIDR(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/8", "10.0.0.1", "::1", "fd00::/8"} {
+		if _, err := ParseCIDR(s); err != nil {
+			t.Error(err)
+		}
+	}
+
+	ipnet, _ := ParseCIDR("10.0.0.1")
+	if ones, _ := ipnet.Mask.Size(); ones != 32 {
+		t.Error("a single address should be a /32 network")
+	}
+
+	for _, s := range []string{"10.0.0.0/33", "not-an-ip", ""} {
+		if _, err := ParseCIDR(s); err == nil {
+			t.Error("should reject ", s)
+		}
+	}
+}
+
+// writeConfig writes the configuration json to a temporary file
+func writeConfig(t *testing.T, json string) string {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(json); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{"masters": ["10.0.0.1:5050"], "resolvers": ["8.8.8.8"], "ttl": 30}`)
+	defer os.Remove(path)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TTL != 30 || c.Port != 53 || c.File != path {
+		t.Errorf("unexpected configuration %+v", c)
+	}
+
+	for _, json := range []string{`{"masters": [`, `{"resolvers": ["8.8.8.8"]}`, `{"masters": ["10.0.0.1"], "stale": {"policy": "never"}}`} {
+		if err = ioutil.WriteFile(path, []byte(json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = LoadConfig(path); err == nil {
+			t.Errorf("%s should be rejected", json)
+		}
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	cur := Config{TTL: 60, Port: 53, Masters: []string{"10.0.0.1:5050"}, File: "a.json"}
+	next := Config{TTL: 30, Port: 5353, Masters: []string{"10.0.0.2:5050"}, File: "b.json"}
+
+	c, restart := cur.Reload(next)
+	if c.TTL != 30 || c.Masters[0] != "10.0.0.2:5050" {
+		t.Error("reloadable settings should be applied")
+	}
+	if c.Port != 53 || c.File != "a.json" {
+		t.Error("other settings should be kept")
+	}
+	if !reflect.DeepEqual(restart, []string{"Port"}) {
+		t.Error("should report the changed settings needing a restart, got ", restart)
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	c := Config{
+		Masters:     []string{"10.0.0.1:5050", "zk://user:zkmaster@10.0.0.1:2181,10.0.0.2:2181/mesos"},
+		Zk:          "zk://user:zkpass@10.0.0.1:2181/mesos",
+		MesosClient: MesosClientConfig{Username: "user", Password: "clientpass", Token: "clienttoken"},
+		Updates:     UpdatesConfig{Keys: []TSIGKey{{Name: "update.mesos.", Secret: "tsigsecret"}}},
+	}
+	b, err := json.Marshal(c.Redacted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"zkmaster", "zkpass", "clientpass", "clienttoken", "tsigsecret"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("should not print %s: %s", secret, b)
+		}
+	}
+	if !strings.Contains(string(b), "zk://10.0.0.1:2181,10.0.0.2:2181/mesos") || !strings.Contains(string(b), "update.mesos.") {
+		t.Error("should print the rest of the configuration, got ", string(b))
+	}
+	if c.Updates.Keys[0].Secret != "tsigsecret" || c.Zk != "zk://user:zkpass@10.0.0.1:2181/mesos" {
+		t.Error("should not change the configuration")
+	}
+}
+
+func TestDNSListeners(t *testing.T) {
+	c := Config{Listener: "0.0.0.0", Port: 53}
+	want := []ListenerConfig{{"0.0.0.0", 53, []string{"udp", "tcp"}, []string{RoleAuthoritative, RoleRecursion}}}
+	if got := c.DNSListeners(); !reflect.DeepEqual(got, want) {
+		t.Errorf("should default to listener and port, got %+v", got)
+	}
+
+	c.Listeners = []ListenerConfig{
+		{Address: "127.0.0.1", Roles: []string{RoleRecursion}},
+		{Address: "203.0.113.1", Port: 5353, Protocols: []string{"udp"}, Roles: []string{RoleAuthoritative}},
+		{Roles: []string{RoleAuthoritative}},
+	}
+	ls := c.DNSListeners()
+	if ls[0].Addr() != "127.0.0.1:53" || ls[1].Addr() != "203.0.113.1:5353" || ls[2].Addr() != "0.0.0.0:53" {
+		t.Errorf("should default addresses and ports, got %+v", ls)
+	}
+	if len(ls[0].Protocols) != 2 || !reflect.DeepEqual(ls[1].Protocols, []string{"udp"}) {
+		t.Errorf("should default protocols, got %+v", ls)
+	}
+	if got := c.authoritativeAddresses(); !reflect.DeepEqual(got, []string{"203.0.113.1", "0.0.0.0"}) {
+		t.Error("only authoritative listeners should be published, got ", got)
+	}
+}
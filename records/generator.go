@@ -3,11 +3,9 @@
 package records
 
 import (
-	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"net"
-	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
@@ -19,8 +17,10 @@ import (
 type rrs map[string][]string
 
 type slave struct {
-	Id       string `json:"id"`
-	Hostname string `json:"hostname"`
+	Id         string                 `json:"id"`
+	Hostname   string                 `json:"hostname"`
+	Pid        string                 `json:"pid"`
+	Attributes map[string]interface{} `json:"attributes"`
 }
 
 // Slaves is a mapping of id to hostname read in from state.json
@@ -31,8 +31,7 @@ type Resources struct {
 	Ports string `json:"ports"`
 }
 
-// Tasks holds mesos task information read in from state.json
-type Tasks []struct {
+type task struct {
 	FrameworkId string `json:"framework_id"`
 	Id          string `json:"id"`
 	Name        string `json:"name"`
@@ -41,17 +40,23 @@ type Tasks []struct {
 	Resources   `json:"resources"`
 }
 
-// Frameworks holds mesos frameworks information read in from state.json
-type Frameworks []struct {
+// Tasks holds mesos task information read in from state.json
+type Tasks []task
+
+type framework struct {
 	Tasks `json:"tasks"`
 	Name  string `json:"name"`
 }
 
+// Frameworks holds mesos frameworks information read in from state.json
+type Frameworks []framework
+
 // StateJSON is a representation of mesos master state.json
 type StateJSON struct {
 	Frameworks `json:"frameworks"`
 	Slaves     `json:"slaves"`
 	Leader     string `json:"leader"`
+	Pid        string `json:"pid"`
 }
 
 // RecordGenerator is a tmp mapping of resource records and slaves
@@ -59,80 +64,48 @@ type StateJSON struct {
 // prob. want to break apart
 // refactor me - prob. not needed
 type RecordGenerator struct {
-	As   rrs
-	SRVs rrs
+	As     rrs
+	SRVs   rrs
+	CNAMEs rrs
 	Slaves
+
+	// indexes that keep generation linear in the number of tasks
+	slaveHosts map[string]string          // slave id -> hostname
+	aHosts     map[string]map[string]bool // A name -> hosts already inserted
 }
 
 // hostBySlaveId looks up a hostname by slave_id
 func (rg *RecordGenerator) hostBySlaveId(slaveId string) (string, error) {
-	for i := 0; i < len(rg.Slaves); i++ {
-		if rg.Slaves[i].Id == slaveId {
-			return rg.Slaves[i].Hostname, nil
-		}
+	if rg.slaveHosts == nil {
+		rg.indexSlaves()
+	}
+	if host, ok := rg.slaveHosts[slaveId]; ok {
+		return host, nil
 	}
 
 	return "", errors.New("not found")
 }
 
-// loadFromMaster loads state.json from mesos master
-func (rg *RecordGenerator) loadFromMaster(ip string, port string) (sj StateJSON) {
-	// tls ?
-	url := "http://" + ip + ":" + port + "/master/state.json"
-
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logging.Error.Println(err)
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logging.Error.Println(err)
+// indexSlaves indexes the hostnames of the slaves by slave id
+func (rg *RecordGenerator) indexSlaves() {
+	rg.slaveHosts = make(map[string]string, len(rg.Slaves))
+	for _, s := range rg.Slaves {
+		rg.slaveHosts[s.Id] = s.Hostname
 	}
-
-	err = json.Unmarshal(body, &sj)
-	if err != nil {
-		logging.Error.Println(err)
-	}
-
-	return sj
 }
 
-// leaderIP returns the ip for the mesos master
+// leaderIP returns the ip for the mesos master, or an empty string if
+// leader is not a master pid
 func leaderIP(leader string) string {
-	pair := strings.Split(leader, "@")[1]
-	return strings.Split(pair, ":")[0]
-}
-
-// loadWrap catches an attempt to load state.json from a mesos master
-// attempts can fail from down server or mesos master secondary
-// it also reloads from a different master if the master it attempted to
-// load from was not the leader
-func (rg *RecordGenerator) loadWrap(ip string, port string) (StateJSON, error) {
-	var err error
-	var sj StateJSON
-
-	defer func() {
-		if rec := recover(); rec != nil {
-			err = errors.New("can't connect to mesos")
-		}
-
-	}()
-
-	logging.VeryVerbose.Println("reloading from master " + ip)
-	sj = rg.loadFromMaster(ip, port)
-
-	if rip := leaderIP(sj.Leader); rip != ip {
-		logging.VeryVerbose.Println("Warning: master changed to " + ip)
-		sj = rg.loadFromMaster(rip, port)
+	i := strings.LastIndex(leader, "@")
+	if i < 0 {
+		return ""
 	}
-
-	return sj, err
+	host, _, err := getProto(leader[i+1:])
+	if err != nil {
+		return ""
+	}
+	return host
 }
 
 // yankPorts takes an array of port ranges
@@ -157,77 +130,58 @@ func yankPorts(ports string) []string {
 	return yports
 }
 
-// findMaster tries each master and looks for the leader
-// if no leader responds it errors
-func (rg *RecordGenerator) findMaster(c *Config) (StateJSON, error) {
-	var sj StateJSON
-
-	if leader := c.getLeader(); leader != "" {
-		logging.VeryVerbose.Println("Zookeeper says the leader is: ", leader)
-		ip, port, err := getProto(leader)
-		if err != nil {
-			logging.Error.Println(err)
+// findMaster loads state.json from the leading master. The leader reported
+// by Zookeeper and the one found by the previous refresh are tried first;
+// failing those, all masters are probed concurrently for the leader so that
+// state.json is downloaded once, from the leader only.
+func (rg *RecordGenerator) findMaster(client *MasterClient, zkLeader string, masters []string) (StateJSON, error) {
+	tried := map[string]bool{}
+	for _, leader := range []string{zkLeader, client.KnownLeader()} {
+		if leader == "" || tried[leader] {
+			continue
 		}
+		tried[leader] = true
 
-		sj, _ = rg.loadWrap(ip, port)
-		if sj.Leader == "" {
-			logging.Verbose.Println("Warning: Zookeeper is wrong about leader")
-			if len(c.Masters) == 0 {
-				return sj, errors.New("no master")
-			} else {
-				logging.Verbose.Println("Warning: falling back to Masters config field: ", c.Masters)
-			}
-		} else {
+		sj, err := client.LeaderState(leader)
+		if err == nil {
 			return sj, nil
 		}
+		logging.Error.Println(err)
+		logging.Verbose.Println("Warning: leader " + leader + " is gone")
 	}
 
-	// try each listed mesos master before dying
-	for i := 0; i < len(c.Masters); i++ {
-		ip, port, err := getProto(c.Masters[i])
-		if err != nil {
-			logging.Error.Println(err)
-		}
-
-		sj, _ = rg.loadWrap(ip, port)
-
-		if sj.Leader == "" {
-			logging.VeryVerbose.Println("Warning: not a leader - trying next one")
-
-			if len(c.Masters)-1 == i {
-				return sj, errors.New("no master")
-			}
-
-		} else {
-			return sj, nil
-		}
-
+	if len(masters) == 0 {
+		return StateJSON{}, errors.New("no master")
 	}
 
-	return sj, nil
-}
-
-// should be able to accept
-// ip:port
-// zk://host1:port1,host2:port2,.../path
-// zk://username:password@host1:port1,host2:port2,.../path
-// file:///path/to/file (where file contains one of the above)
-func getProto(pair string) (string, string, error) {
-	h := strings.Split(pair, ":")
-	return h[0], h[1], nil
+	logging.VeryVerbose.Println("probing masters for the leader: ", masters)
+	leader, err := client.ProbeLeader(masters)
+	if err != nil {
+		return StateJSON{}, err
+	}
+	return client.LeaderState(leader)
 }
 
 // ParseState parses a state.json from a mesos master
 // it sets the resource records map for the resolver
 // with the following format
 //
-//  _<tag>.<service>.<framework>._<protocol>..mesos
+//	_<tag>.<service>.<framework>._<protocol>..mesos
+//
 // it also tries different mesos masters if one is not up
 // this will shudown if it can't connect to a mesos master
-func (rg *RecordGenerator) ParseState(config *Config) error {
+func (rg *RecordGenerator) ParseState(client *MasterClient, zk *ZKDetector, config *Config) error {
+
+	// file addresses are re-read on each refresh
+	masters, err := ResolveMasters(config.Masters)
+	if err != nil {
+		logging.Error.Println(err)
+		return err
+	}
+	masters = mergeMasters(zk.Masters(), masters)
 
 	// try each listed mesos master before dying
-	sj, err := rg.findMaster(config)
+	sj, err := rg.findMaster(client, zk.Leader(), masters)
 	if err != nil {
 		logging.Error.Println("no master")
 		return err
@@ -239,34 +193,49 @@ func (rg *RecordGenerator) ParseState(config *Config) error {
 		return err
 	}
 
-	rg.InsertState(sj, config.Domain, config.Mname, config.Listener, config.Masters)
+	rg.InsertState(sj, config.Domain, config.Mname, config.authoritativeAddresses(), masters)
 	return nil
 }
 
+// mergeMasters appends the masters of b missing from a
+func mergeMasters(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, m := range a {
+		seen[m] = true
+	}
+	for _, m := range b {
+		if !seen[m] {
+			seen[m] = true
+			a = append(a, m)
+		}
+	}
+	return a
+}
+
 // cleanName sanitizes invalid characters
 func cleanName(tname string) string {
 	return stripInvalid(tname)
 }
 
+// invalidChars matches non-valid hostname characters
+var invalidChars = regexp.MustCompile("[^\\w-.\\.]")
+
 // stripInvalid remove any non-valid hostname characters
 func stripInvalid(tname string) string {
-	reg, err := regexp.Compile("[^\\w-.\\.]")
-	if err != nil {
-		logging.Error.Println(err)
-	}
-
-	s := reg.ReplaceAllString(tname, "")
+	s := invalidChars.ReplaceAllString(tname, "")
 
 	return strings.ToLower(strings.Replace(s, "_", "", -1))
 }
 
 // InsertState transforms a StateJSON into RecordGenerator RRs
 func (rg *RecordGenerator) InsertState(sj StateJSON, domain string, mname string,
-	listener string, masters []string) error {
+	listeners []string, masters []string) error {
 	rg.Slaves = sj.Slaves
+	rg.indexSlaves()
 
 	rg.SRVs = make(rrs)
 	rg.As = make(rrs)
+	rg.aHosts = make(map[string]map[string]bool)
 
 	f := sj.Frameworks
 
@@ -298,7 +267,7 @@ func (rg *RecordGenerator) InsertState(sj StateJSON, domain string, mname string
 					// FIXME - 3 nested loops
 					for s := 0; s < len(sports); s++ {
 						//var srvhost string = tname + "." + fname + "." + domain + ":" + sports[s]
-						var srvhost string =  trec + ":" + sports[s]
+						var srvhost string = trec + ":" + sports[s]
 
 						tcp := "_" + tname + "._tcp." + tail
 						udp := "_" + tname + "._udp." + tail
@@ -313,20 +282,29 @@ func (rg *RecordGenerator) InsertState(sj StateJSON, domain string, mname string
 		}
 	}
 
-	rg.listenerRecord(listener, mname)
+	rg.listenerRecord(listeners, mname)
 	rg.masterRecord(domain, masters, sj.Leader)
 	return nil
 }
 
-// listenerRecord sets the A record for the mesos-dns server in case
-// there is a request for it's hostname (eg: from SOA mname)
-func (rg *RecordGenerator) listenerRecord(listener string, mname string) {
-	if listener == "0.0.0.0" {
-		rg.setFromLocal(listener, mname)
-	} else if listener == "127.0.0.1" {
-		rg.insertRR(mname, "127.0.0.1", "A")
-	} else {
-		rg.insertRR(mname, listener, "A")
+// listenerRecord sets the A records for the mesos-dns server in case
+// there is a request for it's hostname (eg: from SOA mname), one per
+// IPv4 listener address or every local one for wildcard listeners
+func (rg *RecordGenerator) listenerRecord(listeners []string, mname string) {
+	local := false
+	for _, listener := range listeners {
+		ip := net.ParseIP(listener)
+		switch {
+		case ip == nil:
+		case ip.IsUnspecified():
+			// every local address, once
+			if !local {
+				rg.setFromLocal(listener, mname)
+				local = true
+			}
+		case ip.To4() != nil:
+			rg.insertRR(mname, ip.To4().String(), "A")
+		}
 	}
 }
 
@@ -337,11 +315,13 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 	// A records
 	h := strings.Split(leader, "@")
 	if len(h) < 2 {
-		logging.Error.Println(leader)
+		logging.Error.Println("invalid leader: ", leader)
+		return
 	}
 	ip, port, err := getProto(h[1])
 	if err != nil {
 		logging.Error.Println(err)
+		return
 	}
 	arec := "leader." + domain + "."
 	rg.insertRR(arec, ip, "A")
@@ -364,6 +344,7 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 		ip, _, err := getProto(masters[i])
 		if err != nil {
 			logging.Error.Println(err)
+			continue
 		}
 
 		// A records (master and masterN)
@@ -374,6 +355,76 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 	}
 }
 
+// AddressPolicies are the ways the address of a slave can be chosen:
+// its hostname, the IP it registered with (from its pid) or the value of
+// one of its attributes ("attribute:<name>")
+const (
+	PolicyHostname  = "hostname"
+	PolicyIP        = "ip"
+	PolicyAttribute = "attribute:"
+)
+
+// ValidAddressPolicy returns an error if policy is not a known address
+// selection policy
+func ValidAddressPolicy(policy string) error {
+	switch {
+	case policy == "", policy == PolicyHostname, policy == PolicyIP:
+		return nil
+	case strings.HasPrefix(policy, PolicyAttribute) && len(policy) > len(PolicyAttribute):
+		return nil
+	}
+	return fmt.Errorf("unknown address policy %q", policy)
+}
+
+// slaveAddress returns the address of s chosen by policy, falling back to
+// its hostname when the policy yields nothing
+func slaveAddress(s slave, policy string) string {
+	switch {
+	case policy == PolicyIP:
+		if i := strings.LastIndex(s.Pid, "@"); i >= 0 {
+			if ip := stripHost(s.Pid[i+1:]); ip != "" {
+				return ip
+			}
+		}
+	case strings.HasPrefix(policy, PolicyAttribute):
+		if v, ok := s.Attributes[policy[len(PolicyAttribute):]].(string); ok && v != "" {
+			return v
+		}
+	}
+	return s.Hostname
+}
+
+// Project returns the A records of the generation with the address of
+// every slave chosen by policy instead of its hostname. Records that
+// don't point to a slave are the same in every projection.
+func (rg *RecordGenerator) Project(policy string) map[string][]string {
+	if policy == "" || policy == PolicyHostname {
+		return rg.As
+	}
+
+	addrs := make(map[string]string, len(rg.Slaves))
+	for _, s := range rg.Slaves {
+		addrs[s.Hostname] = slaveAddress(s, policy)
+	}
+
+	as := make(rrs, len(rg.As))
+	for name, hosts := range rg.As {
+		projected := make([]string, 0, len(hosts))
+		seen := make(map[string]bool, len(hosts))
+		for _, host := range hosts {
+			if addr, ok := addrs[host]; ok {
+				host = addr
+			}
+			if !seen[host] {
+				seen[host] = true
+				projected = append(projected, host)
+			}
+		}
+		as[name] = projected
+	}
+	return as
+}
+
 // setFromLocal generates A records for each local interface we are
 // listening on - if this causes problems you should explicitly set the
 // listener address in config.json
@@ -425,30 +476,41 @@ func slaveIdTail(slaveID string) string {
 	return strings.ToLower(fields[len(fields)-1])
 }
 
-// insertRR inserts host to name's map
-// refactor me
+// insertRR inserts host to name's map; A records are inserted once per
+// host
 func (rg *RecordGenerator) insertRR(name string, host string, rtype string) {
-	logging.VeryVerbose.Println("[" + rtype + "]\t" + name + ": " + host)
+	if logging.VeryVerboseFlag {
+		logging.VeryVerbose.Println("[" + rtype + "]\t" + name + ": " + host)
+	}
 
 	if rtype == "A" {
-		if val, ok := rg.As[name]; ok {
-
-			h := stripHost(host)
-			for _, b := range val {
-				if stripHost(b) == h {
-					return
-				}
-			}
-
-			rg.As[name] = append(val, host)
-		} else {
-			rg.As[name] = []string{host}
+		hosts := rg.indexA(name)
+		h := stripHost(host)
+		if hosts[h] {
+			return
 		}
+		hosts[h] = true
+		rg.As[name] = append(rg.As[name], host)
+	} else if rtype == "CNAME" {
+		rg.CNAMEs[name] = append(rg.CNAMEs[name], host)
 	} else {
-		if val, ok := rg.SRVs[name]; ok {
-			rg.SRVs[name] = append(val, host)
-		} else {
-			rg.SRVs[name] = []string{host}
+		rg.SRVs[name] = append(rg.SRVs[name], host)
+	}
+}
+
+// indexA returns the hosts of the A records of name, indexing those
+// inserted without insertRR, like the records of snapshots
+func (rg *RecordGenerator) indexA(name string) map[string]bool {
+	if rg.aHosts == nil {
+		rg.aHosts = make(map[string]map[string]bool)
+	}
+	hosts, ok := rg.aHosts[name]
+	if !ok {
+		hosts = make(map[string]bool, len(rg.As[name])+1)
+		for _, h := range rg.As[name] {
+			hosts[stripHost(h)] = true
 		}
+		rg.aHosts[name] = hosts
 	}
+	return hosts
 }
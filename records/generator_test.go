@@ -1,9 +1,11 @@
 package records
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/mesosphere/mesos-dns/logging"
 	"io/ioutil"
+	"reflect"
 	"testing"
 )
 
@@ -142,7 +144,7 @@ func TestInsertState(t *testing.T) {
 
 	masters := []string{"144.76.157.37:5050"}
 	rg := RecordGenerator{}
-	rg.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", masters)
+	rg.InsertState(sj, "mesos", "mesos-dns.mesos.", []string{"127.0.0.1"}, masters)
 
 	// ensure we are only collecting running tasks
 	_, ok := rg.SRVs["_poseidon._tcp.marathon-0.6.0.mesos."]
@@ -227,3 +229,89 @@ func TestNTasks(t *testing.T) {
 		t.Error("should only have 2 A records")
 	}
 }
+
+func TestProject(t *testing.T) {
+	rg := RecordGenerator{
+		Slaves: Slaves{
+			{Id: "s1", Hostname: "agent1.internal", Pid: "slave(1)@10.0.0.1:5051",
+				Attributes: map[string]interface{}{"public_ip": "203.0.113.1"}},
+			{Id: "s2", Hostname: "agent2.internal", Pid: "slave(1)@10.0.0.2:5051"},
+		},
+		As: rrs{
+			"web.marathon.mesos.": {"agent1.internal", "agent2.internal"},
+			"leader.mesos.":       {"10.0.0.10"},
+		},
+	}
+
+	as := rg.Project(PolicyIP)
+	if hosts := as["web.marathon.mesos."]; len(hosts) != 2 || hosts[0] != "10.0.0.1" || hosts[1] != "10.0.0.2" {
+		t.Error("should use the slave ips, got ", hosts)
+	}
+
+	as = rg.Project("attribute:public_ip")
+	if hosts := as["web.marathon.mesos."]; len(hosts) != 2 || hosts[0] != "203.0.113.1" || hosts[1] != "agent2.internal" {
+		t.Error("should use the attribute, falling back to the hostname, got ", hosts)
+	}
+	if hosts := as["leader.mesos."]; len(hosts) != 1 || hosts[0] != "10.0.0.10" {
+		t.Error("should not change records that don't point to slaves")
+	}
+
+	if hosts := rg.As["web.marathon.mesos."]; hosts[0] != "agent1.internal" {
+		t.Error("projecting should not change the generation")
+	}
+
+	if ValidAddressPolicy("attribute:") == nil || ValidAddressPolicy("public") == nil {
+		t.Error("should reject unknown address policies")
+	}
+}
+
+func TestInsertRRIndexesExisting(t *testing.T) {
+	// records loaded from a snapshot come without indexes
+	rg := &RecordGenerator{As: rrs{"web.mesos.": {"10.0.0.1"}}, SRVs: make(rrs)}
+	rg.insertRR("web.mesos.", "10.0.0.1", "A")
+	rg.insertRR("web.mesos.", "10.0.0.2", "A")
+	rg.insertRR("web.mesos.", "10.0.0.2", "A")
+
+	if got := rg.As["web.mesos."]; len(got) != 2 {
+		t.Error("A records should be inserted once per host, got ", got)
+	}
+}
+
+func TestListenerRecord(t *testing.T) {
+	rg := &RecordGenerator{As: make(rrs), SRVs: make(rrs)}
+	rg.listenerRecord([]string{"10.0.0.1", "::1", "10.0.0.2", "10.0.0.1"}, "mesos-dns.mesos.")
+
+	if got := rg.As["mesos-dns.mesos."]; !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Error("should have an A record per IPv4 listener, got ", got)
+	}
+}
+
+// benchmarkInsertState generates the records of a synthetic cluster
+// running tasks tasks on a slave for every 50 tasks, merged with static
+// records unless only the tasks have records
+func benchmarkInsertState(b *testing.B, tasks int, merge bool) {
+	sj, err := DecodeState(bytes.NewReader(syntheticState(tasks/50, 20, tasks/20, 0)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	masters := []string{"10.0.0.1:5050"}
+	var static []Record
+	if merge {
+		static = []Record{{Name: "registry.mesos.", Type: TypeA, Target: "10.0.0.10", Source: SourceZone}}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		rg := &RecordGenerator{}
+		rg.InsertState(sj, "mesos", "mesos-dns.mesos.", []string{"127.0.0.1"}, masters)
+		Merge([]RecordSet{
+			{Source: SourceZone, Records: static},
+			{Source: SourceMesos, Generation: rg},
+		}, ConflictPrecedence)
+	}
+}
+
+func BenchmarkInsertState10k(b *testing.B)       { benchmarkInsertState(b, 10000, false) }
+func BenchmarkInsertState100k(b *testing.B)      { benchmarkInsertState(b, 100000, false) }
+func BenchmarkInsertState100kMerge(b *testing.B) { benchmarkInsertState(b, 100000, true) }
@@ -0,0 +1,253 @@
+package records
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the names of the environment variables holding settings
+const EnvPrefix = "MESOS_DNS_"
+
+// EnvConfig is the environment variable naming the configuration file
+const EnvConfig = EnvPrefix + "CONFIG"
+
+// EnvSockets is the environment variable listing the sockets a
+// restarting process passes on
+const EnvSockets = EnvPrefix + "SOCKETS"
+
+// ConfigLayers are the sources of a configuration. Settings of the
+// environment override those of the file, and command line flags override
+// both; unset settings keep their defaults.
+type ConfigLayers struct {
+	// File is the json configuration file, empty for none
+	File string
+
+	// Env is the environment, as returned by os.Environ
+	Env []string
+
+	// Flags are the values of the command line flags that were set, by
+	// flag name
+	Flags map[string]string
+}
+
+// configVar is a field of the configuration that can be set from the
+// environment and the command line
+type configVar struct {
+	index []int // of the field in Config
+	kind  reflect.Type
+	name  string // like mesosClient.readTimeout
+	env   string // like MESOS_DNS_MESOS_CLIENT_READ_TIMEOUT
+	flag  string // like mesos-client-read-timeout
+}
+
+// configVars returns the settable fields of the configuration, in order
+func configVars() []configVar {
+	return structVars(reflect.TypeOf(Config{}), nil, "", nil)
+}
+
+// structVars returns the settable fields of the struct type t, nested in
+// the field index named name whose name is made of words
+func structVars(t reflect.Type, index []int, name string, words []string) []configVar {
+	var vars []configVar
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// derived fields aren't settings
+		if f.PkgPath != "" || index == nil && (f.Name == "File" || f.Name == "Mname") {
+			continue
+		}
+		idx := append(append([]int{}, index...), i)
+		ws := append(append([]string{}, words...), fieldWords(f.Name)...)
+		n := fieldName(f.Name)
+		if name != "" {
+			n = name + "." + n
+		}
+		if f.Type.Kind() == reflect.Struct {
+			vars = append(vars, structVars(f.Type, idx, n, ws)...)
+			continue
+		}
+
+		v := configVar{index: idx, kind: f.Type, name: n}
+		for _, w := range ws {
+			v.env += "_" + strings.ToUpper(w)
+			v.flag += "-" + strings.ToLower(w)
+		}
+		v.env = EnvPrefix + v.env[1:]
+		v.flag = v.flag[1:]
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+// fieldName returns the name of a field as written in json, like httpPort
+// for HTTPPort
+func fieldName(field string) string {
+	words := fieldWords(field)
+	return strings.ToLower(words[0]) + strings.Join(words[1:], "")
+}
+
+// acronyms are the words of field names written in capitals
+var acronyms = []string{"IPv4", "IPv6", "HTTPS", "HTTP", "QPS", "TTL", "ACL", "ECS", "CA", "MB"}
+
+// fieldWords splits the name of a field into words, like HTTPPort into
+// HTTP and Port
+func fieldWords(name string) []string {
+	var words []string
+	for len(name) > 0 {
+		n := 0
+		for _, a := range acronyms {
+			if strings.HasPrefix(name, a) && (len(name) == len(a) || !isLower(name[len(a)])) {
+				n = len(a)
+				break
+			}
+		}
+		if n == 0 {
+			for n = 1; n < len(name) && !isUpper(name[n]); n++ {
+			}
+		}
+		words = append(words, name[:n])
+		name = name[n:]
+	}
+	return words
+}
+
+func isLower(b byte) bool { return b >= 'a' && b <= 'z' }
+func isUpper(b byte) bool { return b >= 'A' && b <= 'Z' }
+
+// set parses s into the field of c. Lists are comma separated, or json
+// arrays like structured fields.
+func (v configVar) set(c *Config, s string) error {
+	f := reflect.ValueOf(c).Elem().FieldByIndex(v.index)
+	switch v.kind.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		f.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		f.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		f.SetBool(b)
+	default:
+		if v.kind == reflect.TypeOf([]string{}) && !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			list := []string{}
+			for _, e := range strings.Split(s, ",") {
+				if e = strings.TrimSpace(e); e != "" {
+					list = append(list, e)
+				}
+			}
+			f.Set(reflect.ValueOf(list))
+			return nil
+		}
+		p := reflect.New(v.kind)
+		if err := json.Unmarshal([]byte(s), p.Interface()); err != nil {
+			return fmt.Errorf("%q is not a json %s: %v", s, v.kind, err)
+		}
+		f.Set(p.Elem())
+	}
+	return nil
+}
+
+// apply sets the fields of c from the environment and then the flags of
+// the layers
+func (l ConfigLayers) apply(c *Config) ConfigErrors {
+	var errs ConfigErrors
+	vars := configVars()
+	byEnv := make(map[string]configVar, len(vars))
+	byFlag := make(map[string]configVar, len(vars))
+	for _, v := range vars {
+		byEnv[v.env] = v
+		byFlag[v.flag] = v
+	}
+
+	var envs []string
+	env := make(map[string]string)
+	for _, kv := range l.Env {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv, EnvPrefix) || kv[:i] == EnvConfig || kv[:i] == EnvSockets {
+			continue
+		}
+		if _, ok := env[kv[:i]]; !ok {
+			envs = append(envs, kv[:i])
+		}
+		env[kv[:i]] = kv[i+1:]
+	}
+	for _, name := range envs {
+		v, ok := byEnv[name]
+		if !ok {
+			errs.add(name, "unknown variable")
+			continue
+		}
+		if err := v.set(c, env[name]); err != nil {
+			errs.add(name, "%v", err)
+		}
+	}
+
+	flags := make([]string, 0, len(l.Flags))
+	for name := range l.Flags {
+		flags = append(flags, name)
+	}
+	sort.Strings(flags)
+	for _, name := range flags {
+		v, ok := byFlag[name]
+		if !ok {
+			errs.add("-"+name, "unknown flag")
+			continue
+		}
+		if err := v.set(c, l.Flags[name]); err != nil {
+			errs.add("-"+name, "%v", err)
+		}
+	}
+	return errs
+}
+
+// layerFlag is a command line flag recording its value into the layers
+type layerFlag struct {
+	name   string
+	layers *ConfigLayers
+	bool   bool
+}
+
+func (f *layerFlag) String() string {
+	if f.layers == nil {
+		return ""
+	}
+	return f.layers.Flags[f.name]
+}
+
+func (f *layerFlag) Set(s string) error {
+	if f.layers.Flags == nil {
+		f.layers.Flags = make(map[string]string)
+	}
+	f.layers.Flags[f.name] = s
+	return nil
+}
+
+func (f *layerFlag) IsBoolFlag() bool {
+	return f.bool
+}
+
+// RegisterFlags defines a flag on fs for every setting of the
+// configuration, recording the flags set into the layers. Values are
+// checked when the configuration is loaded.
+func (l *ConfigLayers) RegisterFlags(fs *flag.FlagSet) {
+	for _, v := range configVars() {
+		usage := fmt.Sprintf("sets %s (environment %s)", v.name, v.env)
+		fs.Var(&layerFlag{name: v.flag, layers: l, bool: v.kind.Kind() == reflect.Bool}, v.flag, usage)
+	}
+}
@@ -0,0 +1,82 @@
+package records
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestConfigLayers(t *testing.T) {
+	path := writeConfig(t, `{"masters": ["10.0.0.1:5050"], "resolvers": ["8.8.8.8"], "ttl": 30, "port": 5353, "domain": "file"}`)
+	defer os.Remove(path)
+
+	l := ConfigLayers{
+		File:  path,
+		Env:   []string{"HOME=/root", "MESOS_DNS_TTL=20", "MESOS_DNS_DOMAIN=env", "MESOS_DNS_ACL_MESOS=10.0.0.0/8, 10.1.0.1", "MESOS_DNS_CONFIG=x.json"},
+		Flags: map[string]string{"domain": "flag", "mesos-client-https": "true", "views": `[{"name": "a"}]`},
+	}
+	c, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 5353 || c.TTL != 20 || c.Domain != "flag" {
+		t.Error("flags should override the environment, which overrides the file: ", c.Port, c.TTL, c.Domain)
+	}
+	if !reflect.DeepEqual(c.ACL.Mesos, []string{"10.0.0.0/8", "10.1.0.1"}) || !c.MesosClient.HTTPS || c.Views[0].Name != "a" {
+		t.Errorf("unexpected configuration %+v", c)
+	}
+
+	if again, err := c.Reread(); err != nil || again.TTL != 20 || again.Domain != "flag" {
+		t.Error("rereading should apply the same layers")
+	}
+}
+
+func TestConfigLayersErrors(t *testing.T) {
+	l := ConfigLayers{
+		Env:   []string{"MESOS_DNS_MASTERS=10.0.0.1:5050", "MESOS_DNS_RESOLVERS=8.8.8.8", "MESOS_DNS_TLL=60", "MESOS_DNS_PORT=x"},
+		Flags: map[string]string{"http-on": "maybe", "nope": "1"},
+	}
+	_, err := l.Load()
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatal("should return configuration errors, got ", err)
+	}
+	fields := map[string]bool{}
+	for _, e := range errs {
+		fields[e.Field] = true
+	}
+	for _, f := range []string{"MESOS_DNS_TLL", "MESOS_DNS_PORT", "-http-on", "-nope"} {
+		if !fields[f] {
+			t.Errorf("should report %s, got %v", f, errs)
+		}
+	}
+}
+
+func TestRegisterFlags(t *testing.T) {
+	var l ConfigLayers
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-http-on=false", "-stale-status-record", "-rate-limit-ipv4-prefix-len", "16"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"http-on": "false", "stale-status-record": "true", "rate-limit-ipv4-prefix-len": "16"}
+	if !reflect.DeepEqual(l.Flags, want) {
+		t.Errorf("flags %v, want %v", l.Flags, want)
+	}
+}
+
+func TestFieldWords(t *testing.T) {
+	for name, want := range map[string][]string{
+		"HTTPPort":      {"HTTP", "Port"},
+		"HTTPS":         {"HTTPS"},
+		"CACertFile":    {"CA", "Cert", "File"},
+		"IPv4PrefixLen": {"IPv4", "Prefix", "Len"},
+		"MaxSizeMB":     {"Max", "Size", "MB"},
+		"Zk":            {"Zk"},
+	} {
+		if got := fieldWords(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package records
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// defaultMasterPort is assumed when a redirect doesn't carry a port
+const defaultMasterPort = "5050"
+
+// KnownLeader returns the address of the master that led at the end of the
+// previous refresh, or "" if there is none
+func (mc *MasterClient) KnownLeader() string {
+	mc.leaderLock.Lock()
+	defer mc.leaderLock.Unlock()
+	return mc.leader
+}
+
+func (mc *MasterClient) setLeader(addr string) {
+	mc.leaderLock.Lock()
+	mc.leader = addr
+	mc.leaderLock.Unlock()
+}
+
+// Redirect asks the master at host:port who leads, using the cheap
+// /master/redirect endpoint instead of downloading state.json. It returns
+// the host:port of the leader.
+func (mc *MasterClient) Redirect(host, port string) (string, error) {
+	u := mc.scheme + "://" + net.JoinHostPort(host, port) + "/master/redirect"
+	req, err := mc.newRequest(u)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := mc.probe.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusTemporaryRedirect, http.StatusFound, http.StatusMovedPermanently:
+	default:
+		return "", &statusError{url: u, code: resp.StatusCode}
+	}
+	return redirectLeader(resp.Header.Get("Location"))
+}
+
+// redirectLeader extracts the leader's host:port from the Location of a
+// redirect, which may be scheme relative (//host:port/...)
+func redirectLeader(loc string) (string, error) {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", errors.New("redirect without a leader: " + loc)
+	}
+	if _, _, err = net.SplitHostPort(u.Host); err != nil {
+		return net.JoinHostPort(strings.Trim(u.Host, "[]"), defaultMasterPort), nil
+	}
+	return u.Host, nil
+}
+
+// ProbeLeader asks all masters concurrently who leads and returns the
+// first answer
+func (mc *MasterClient) ProbeLeader(masters []string) (string, error) {
+	type answer struct {
+		leader string
+		err    error
+	}
+
+	answers := make(chan answer, len(masters))
+	for _, m := range masters {
+		go func(m string) {
+			host, port, err := getProto(m)
+			if err != nil {
+				answers <- answer{err: err}
+				return
+			}
+			leader, err := mc.Redirect(host, port)
+			answers <- answer{leader, err}
+		}(m)
+	}
+
+	for range masters {
+		a := <-answers
+		if a.err == nil {
+			return a.leader, nil
+		}
+		logging.VeryVerbose.Println("Warning: probing master failed: ", a.err)
+	}
+	return "", errors.New("no master knows the leader")
+}
+
+// LeaderState downloads state.json from the master at addr (host:port).
+// Should that master have lost its leadership, the state of the master it
+// names as leader is downloaded instead. The master that served the state
+// is remembered as the known leader.
+func (mc *MasterClient) LeaderState(addr string) (StateJSON, error) {
+	host, port, err := getProto(addr)
+	if err != nil {
+		return StateJSON{}, err
+	}
+
+	logging.VeryVerbose.Println("reloading from master " + addr)
+	sj, err := mc.State(host, port)
+	if err != nil {
+		mc.setLeader("")
+		return sj, err
+	}
+
+	if !sj.leading(host) {
+		rip := leaderIP(sj.Leader)
+		if rip == "" {
+			mc.setLeader("")
+			return sj, errors.New("master " + addr + " doesn't know the leader")
+		}
+		logging.VeryVerbose.Println("Warning: master changed to " + rip)
+		host, port = rip, leaderPort(sj.Leader, port)
+		if sj, err = mc.State(host, port); err != nil {
+			mc.setLeader("")
+			return sj, err
+		}
+	}
+
+	mc.setLeader(net.JoinHostPort(host, port))
+	return sj, nil
+}
+
+// leading tells whether the state was served by the leader. Masters that
+// don't report their own pid are compared by the address they were
+// reached at.
+func (sj StateJSON) leading(host string) bool {
+	if sj.Pid != "" {
+		return sj.Pid == sj.Leader
+	}
+	return leaderIP(sj.Leader) == host
+}
+
+// leaderPort returns the port of a master pid, or def if it has none
+func leaderPort(pid, def string) string {
+	if _, port, err := net.SplitHostPort(pid[strings.LastIndex(pid, "@")+1:]); err == nil {
+		return port
+	}
+	return def
+}
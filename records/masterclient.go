@@ -0,0 +1,241 @@
+package records
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// MasterClient talks to the HTTP API of Mesos masters
+type MasterClient struct {
+	client  *http.Client
+	probe   *http.Client
+	scheme  string
+	auth    func(*http.Request)
+	retries int
+	backoff time.Duration
+	idle    time.Duration // longest wait for a read of a response body
+
+	// leader is the master found leading by the previous refresh
+	leader     string
+	leaderLock sync.Mutex
+}
+
+// statusError is returned for unexpected HTTP status codes
+type statusError struct {
+	url  string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: unexpected status %d %s", e.url, e.code, http.StatusText(e.code))
+}
+
+// NewMasterClient returns a client for the masters configured by c. It
+// fails if the certificates can't be loaded.
+func NewMasterClient(c MesosClientConfig) (*MasterClient, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	connect := time.Duration(c.ConnectTimeout) * time.Second
+	read := time.Duration(c.ReadTimeout) * time.Second
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		Dial:                  (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).Dial,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connect,
+		ResponseHeaderTimeout: read,
+	}
+
+	// a large state may take long to download, so the body is only read
+	// with a timeout per read
+	mc := &MasterClient{
+		client: &http.Client{Transport: transport},
+		probe: &http.Client{
+			Transport: transport,
+			Timeout:   connect + read,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		scheme:  "http",
+		retries: c.Retries,
+		backoff: time.Duration(c.RetryBackoffMillis) * time.Millisecond,
+		idle:    read,
+	}
+	if c.HTTPS {
+		mc.scheme = "https"
+	}
+
+	switch {
+	case c.Token != "":
+		mc.auth = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+c.Token) }
+	case c.Username != "":
+		mc.auth = func(req *http.Request) { req.SetBasicAuth(c.Username, c.Password) }
+	}
+	return mc, nil
+}
+
+// tlsConfig returns the TLS configuration for https masters, if any
+func (c MesosClientConfig) tlsConfig() (*tls.Config, error) {
+	if !c.HTTPS {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if c.CACertFile != "" {
+		pem, err := ioutil.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA certificate: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACertFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// State downloads the state of the master at host:port. The response is
+// decoded as it arrives, so the state is never held in memory as a whole.
+func (mc *MasterClient) State(host, port string) (StateJSON, error) {
+	var sj StateJSON
+
+	url := mc.scheme + "://" + net.JoinHostPort(host, port) + "/master/state.json"
+	err := mc.get(url, func(body io.Reader) (err error) {
+		sj, err = DecodeState(body)
+		return err
+	})
+	return sj, err
+}
+
+// get fetches url and passes the body to decode, retrying with
+// exponential backoff on network errors and server errors
+func (mc *MasterClient) get(url string, decode func(io.Reader) error) error {
+	var err error
+
+	for attempt := 0; attempt <= mc.retries; attempt++ {
+		if attempt > 0 {
+			wait := mc.backoff << uint(attempt-1)
+			logging.VeryVerbose.Printf("retrying %s in %s: %v\n", url, wait, err)
+			time.Sleep(wait)
+		}
+
+		if err = mc.getOnce(url, decode); err == nil {
+			return nil
+		}
+		if se, ok := err.(*statusError); ok && se.code < http.StatusInternalServerError {
+			break
+		}
+	}
+	return err
+}
+
+// newRequest returns an authenticated GET request for url
+func (mc *MasterClient) newRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if mc.auth != nil {
+		mc.auth(req)
+	}
+	return req, nil
+}
+
+func (mc *MasterClient) getOnce(url string, decode func(io.Reader) error) error {
+	req, err := mc.newRequest(url)
+	if err != nil {
+		return err
+	}
+	// asking for gzip explicitly leaves decompression to us
+	req.Header.Set("Accept-Encoding", "gzip")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	resp, err := mc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{url: url, code: resp.StatusCode}
+	}
+
+	idle := newIdleReader(resp.Body, mc.idle, cancel)
+	defer idle.stop()
+	var body io.Reader = idle
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(idle)
+		if err != nil {
+			return fmt.Errorf("%s: %v", url, err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	if err = decode(body); err == io.EOF {
+		return errors.New(url + ": empty response")
+	} else if err != nil {
+		return fmt.Errorf("%s: %v", url, err)
+	}
+	return nil
+}
+
+// idleReader reads a response body, canceling the request once no read
+// returned for timeout
+type idleReader struct {
+	body    io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32 // set once the request was canceled
+}
+
+// newIdleReader returns a reader of body calling cancel unless every read
+// returns within timeout
+func newIdleReader(body io.Reader, timeout time.Duration, cancel func()) *idleReader {
+	r := &idleReader{body: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&r.expired, 1)
+		cancel()
+	})
+	return r
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if atomic.LoadInt32(&r.expired) == 1 {
+		return n, fmt.Errorf("no data received for %v", r.timeout)
+	}
+	r.timer.Reset(r.timeout)
+	return n, err
+}
+
+// stop stops the timeout
+func (r *idleReader) stop() {
+	r.timer.Stop()
+}
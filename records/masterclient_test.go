@@ -0,0 +1,243 @@
+package records
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeMaster(t *testing.T, handler http.HandlerFunc) (*httptest.Server, string, string) {
+	ts := httptest.NewServer(handler)
+	host, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, host, port
+}
+
+func TestMasterClientAuth(t *testing.T) {
+	var auth string
+	ts, host, port := fakeMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"leader": "master@127.0.0.1:5050"}`))
+	})
+	defer ts.Close()
+
+	mc, err := NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1, Token: "s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sj, err := mc.State(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sj.Leader != "master@127.0.0.1:5050" {
+		t.Error("not parsing state")
+	}
+	if auth != "Bearer s3cr3t" {
+		t.Error("not sending the bearer token, got ", auth)
+	}
+
+	mc, _ = NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1, Username: "dns", Password: "pw"})
+	if _, err = mc.State(host, port); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Basic ZG5zOnB3" {
+		t.Error("not sending basic authentication, got ", auth)
+	}
+}
+
+func TestMasterClientRetries(t *testing.T) {
+	requests := 0
+	ts, host, port := fakeMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"leader": "master@127.0.0.1:5050"}`))
+	})
+	defer ts.Close()
+
+	mc, _ := NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1, Retries: 2, RetryBackoffMillis: 1})
+	if _, err := mc.State(host, port); err != nil {
+		t.Error("should succeed after retrying: ", err)
+	}
+
+	requests = 0
+	mc, _ = NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1, Retries: 1, RetryBackoffMillis: 1})
+	if _, err := mc.State(host, port); err == nil {
+		t.Error("should fail once the retries are exhausted")
+	}
+}
+
+func TestMasterClientErrors(t *testing.T) {
+	requests := 0
+	ts, host, port := fakeMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	})
+
+	mc, _ := NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1, Retries: 3, RetryBackoffMillis: 1})
+	if _, err := mc.State(host, port); err == nil {
+		t.Error("should fail on client errors")
+	}
+	if requests != 1 {
+		t.Error("should not retry client errors")
+	}
+
+	ts.Close()
+	if _, err := mc.State(host, port); err == nil {
+		t.Error("should fail for unreachable masters instead of panicking")
+	}
+}
+
+func TestMasterClientIdleTimeout(t *testing.T) {
+	// the state arrives slowly, in pieces
+	slowMaster := func(stall time.Duration) (*httptest.Server, string, string) {
+		return fakeMaster(t, func(w http.ResponseWriter, r *http.Request) {
+			for _, piece := range []string{`{"leader": `, `"master@127.0.0.1:5050", `, `"frameworks": []`, `}`} {
+				w.Write([]byte(piece))
+				w.(http.Flusher).Flush()
+				time.Sleep(stall)
+			}
+		})
+	}
+	mc, _ := NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1})
+	mc.idle = 100 * time.Millisecond
+
+	ts, host, port := slowMaster(40 * time.Millisecond)
+	defer ts.Close()
+	if _, err := mc.State(host, port); err != nil {
+		t.Error("should read a body longer than the timeout as long as data arrives: ", err)
+	}
+
+	ts, host, port = slowMaster(300 * time.Millisecond)
+	defer ts.Close()
+	if _, err := mc.State(host, port); err == nil || !strings.Contains(err.Error(), "no data received") {
+		t.Error("should give up once no data arrives, got ", err)
+	}
+}
+
+func TestMasterClientTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"leader": "master@127.0.0.1:5050"}`))
+	}))
+	defer ts.Close()
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	ca, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	ca.Close()
+
+	mc, err := NewMasterClient(MesosClientConfig{HTTPS: true, CACertFile: ca.Name(), ConnectTimeout: 1, ReadTimeout: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mc.State(host, port); err != nil {
+		t.Error("should trust the configured CA: ", err)
+	}
+
+	if _, err = NewMasterClient(MesosClientConfig{HTTPS: true, CACertFile: "/nonexistent"}); err == nil {
+		t.Error("should fail for a missing CA certificate")
+	}
+}
+
+func TestFindMaster(t *testing.T) {
+	var leader string
+	states := 0
+	ts, host, port := fakeMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/master/redirect" {
+			w.Header().Set("Location", "//"+r.Host)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		states++
+		w.Write([]byte(`{"leader": "` + leader + `", "pid": "` + leader + `"}`))
+	})
+	defer ts.Close()
+	leader = "master@" + host + ":" + port
+
+	mc, _ := NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1})
+	masters := []string{"127.0.0.1:1", host + ":" + port}
+
+	rg := RecordGenerator{}
+	sj, err := rg.findMaster(mc, "", masters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sj.Leader != leader {
+		t.Error("should find the leader after skipping a down master")
+	}
+	if states != 1 {
+		t.Error("state.json should be downloaded once, got ", states)
+	}
+	if mc.KnownLeader() != host+":"+port {
+		t.Error("should remember the leader, got ", mc.KnownLeader())
+	}
+
+	// the known leader is asked directly on the next refresh
+	masters = nil
+	if _, err = rg.findMaster(mc, "", masters); err != nil {
+		t.Error("should reuse the known leader: ", err)
+	}
+
+	leader = ""
+	masters = []string{host + ":" + port}
+	if _, err = rg.findMaster(mc, "", masters); err == nil {
+		t.Error("should fail when no master knows the leader")
+	}
+	if mc.KnownLeader() != "" {
+		t.Error("should forget a leader that lost track")
+	}
+}
+
+func TestLeaderStateFollowsLeader(t *testing.T) {
+	leader, lhost, lport := fakeMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"leader": "master@` + r.Host + `", "pid": "master@` + r.Host + `"}`))
+	})
+	defer leader.Close()
+	follower, fhost, fport := fakeMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"leader": "master@` + lhost + `:` + lport + `", "pid": "master@` + r.Host + `"}`))
+	})
+	defer follower.Close()
+
+	mc, _ := NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1})
+	sj, err := mc.LeaderState(fhost + ":" + fport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sj.Pid != sj.Leader {
+		t.Error("should have loaded the state of the leader")
+	}
+	if mc.KnownLeader() != lhost+":"+lport {
+		t.Error("should remember the leader instead of the follower, got ", mc.KnownLeader())
+	}
+}
+
+func TestRedirectLeader(t *testing.T) {
+	for loc, want := range map[string]string{
+		"//10.0.0.1:5050":                      "10.0.0.1:5050",
+		"http://10.0.0.1:5051/master/redirect": "10.0.0.1:5051",
+		"//master.example.com":                 "master.example.com:5050",
+		"//[fd00::1]:5050":                     "[fd00::1]:5050",
+	} {
+		got, err := redirectLeader(loc)
+		if err != nil || got != want {
+			t.Errorf("redirectLeader(%q) = %q, %v; want %q", loc, got, err, want)
+		}
+	}
+	if _, err := redirectLeader("/master/redirect"); err == nil {
+		t.Error("should reject redirects without a host")
+	}
+}
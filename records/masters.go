@@ -0,0 +1,226 @@
+package records
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// schemes of master addresses
+const (
+	SchemeZk    = "zk"
+	SchemeFile  = "file"
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+)
+
+// MasterAddress is a parsed master address, as found in the masters and
+// zk fields of the configuration. It is one of
+//
+//	host:port
+//	http://host:port or https://host:port
+//	zk://host1:port1,host2:port2,.../path
+//	zk://username:password@host1:port1,host2:port2,.../path
+//	file:///path/to/file (where file contains any of the above)
+//
+// where hosts may be names, IPv4 or bracketed IPv6 addresses.
+type MasterAddress struct {
+	Scheme   string   // "" for plain host:port
+	Hosts    []string // host:port pairs; more than one for zk only
+	Path     string   // the znode of zk and the path of file addresses
+	Username string
+	Password string
+}
+
+// redactAddress returns the master address s without the credentials it
+// may hold, like the user:password@ of a zk:// address
+func redactAddress(s string) string {
+	i := strings.Index(s, "://")
+	if i < 0 {
+		return s
+	}
+	rest := s[i+3:]
+	hosts := rest
+	if j := strings.Index(rest, "/"); j >= 0 {
+		hosts = rest[:j]
+	}
+	j := strings.LastIndex(hosts, "@")
+	if j < 0 {
+		return s
+	}
+	return s[:i+3] + rest[j+1:]
+}
+
+// ParseMasterAddress parses a master address; errors describe what is
+// wrong with it
+func ParseMasterAddress(s string) (MasterAddress, error) {
+	var a MasterAddress
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return a, errors.New("empty master address")
+	}
+
+	if !strings.Contains(s, "://") {
+		host, port, err := getProto(s)
+		if err != nil {
+			return a, err
+		}
+		a.Hosts = []string{net.JoinHostPort(host, port)}
+		return a, nil
+	}
+
+	i := strings.Index(s, "://")
+	a.Scheme = strings.ToLower(s[:i])
+	rest := s[i+3:]
+
+	switch a.Scheme {
+	case SchemeFile:
+		if !strings.HasPrefix(rest, "/") {
+			return a, fmt.Errorf("invalid master address %s: file path must be absolute", s)
+		}
+		a.Path = rest
+		return a, nil
+
+	case SchemeZk:
+		// url.Parse would choke on the list of hosts, so split by hand
+		if j := strings.Index(rest, "/"); j >= 0 {
+			a.Path = rest[j:]
+			rest = rest[:j]
+		}
+		if a.Path == "" || a.Path == "/" {
+			return a, fmt.Errorf("invalid master address %s: missing znode path", s)
+		}
+		if j := strings.LastIndex(rest, "@"); j >= 0 {
+			creds := rest[:j]
+			rest = rest[j+1:]
+			k := strings.Index(creds, ":")
+			if k < 0 {
+				return a, fmt.Errorf("invalid master address %s: credentials must be username:password", s)
+			}
+			a.Username, a.Password = creds[:k], creds[k+1:]
+		}
+		for _, h := range strings.Split(rest, ",") {
+			host, port, err := net.SplitHostPort(h)
+			if err != nil || host == "" || port == "" {
+				return a, fmt.Errorf("invalid master address %s: bad zookeeper host %q", s, h)
+			}
+			a.Hosts = append(a.Hosts, net.JoinHostPort(host, port))
+		}
+		return a, nil
+
+	case SchemeHTTP, SchemeHTTPS:
+		u, err := url.Parse(s)
+		if err != nil {
+			return a, fmt.Errorf("invalid master address %s: %v", s, err)
+		}
+		host, port, err := getProto(u.Host)
+		if err != nil {
+			return a, err
+		}
+		a.Hosts = []string{net.JoinHostPort(host, port)}
+		return a, nil
+	}
+	return a, fmt.Errorf("invalid master address %s: unknown scheme %s", s, a.Scheme)
+}
+
+// String formats the address the way it is parsed
+func (a MasterAddress) String() string {
+	switch a.Scheme {
+	case "":
+		return strings.Join(a.Hosts, ",")
+	case SchemeFile:
+		return a.Scheme + "://" + a.Path
+	}
+
+	var creds string
+	if a.Username != "" || a.Password != "" {
+		creds = a.Username + ":" + a.Password + "@"
+	}
+	return a.Scheme + "://" + creds + strings.Join(a.Hosts, ",") + a.Path
+}
+
+// readMasterFile returns the addresses listed in a file, one per line or
+// separated by commas; blank lines and lines starting with # are skipped
+func readMasterFile(path string) ([]MasterAddress, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var as []MasterAddress
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// a zk address lists its hosts separated by commas itself
+		entries := []string{line}
+		if !strings.HasPrefix(line, SchemeZk+"://") {
+			entries = strings.Split(line, ",")
+		}
+		for _, e := range entries {
+			a, err := ParseMasterAddress(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			if a.Scheme == SchemeFile {
+				return nil, fmt.Errorf("%s: nested master file %s", path, a.Path)
+			}
+			as = append(as, a)
+		}
+	}
+	return as, nil
+}
+
+// ResolveMasters expands a list of master addresses into host:port pairs
+// of masters; file addresses are read each time. Zookeeper addresses are
+// left to the Zookeeper detector and skipped.
+func ResolveMasters(masters []string) ([]string, error) {
+	var hosts []string
+	for _, m := range masters {
+		a, err := ParseMasterAddress(m)
+		if err != nil {
+			return nil, err
+		}
+
+		as := []MasterAddress{a}
+		if a.Scheme == SchemeFile {
+			if as, err = readMasterFile(a.Path); err != nil {
+				return nil, err
+			}
+		}
+		for _, a := range as {
+			if a.Scheme != SchemeZk {
+				hosts = append(hosts, a.Hosts...)
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// getProto splits a master address into host and port. It accepts
+// host:port, [ipv6]:port and plain hosts or IPs, which get the default
+// master port.
+func getProto(pair string) (string, string, error) {
+	if pair == "" {
+		return "", "", errors.New("empty master address")
+	}
+	if ip := net.ParseIP(strings.Trim(pair, "[]")); ip != nil {
+		return ip.String(), defaultMasterPort, nil
+	}
+
+	host, port, err := net.SplitHostPort(pair)
+	if err != nil {
+		if strings.Contains(pair, ":") {
+			return "", "", fmt.Errorf("invalid master address %s: %v", pair, err)
+		}
+		host, port = pair, defaultMasterPort
+	}
+	if host == "" || port == "" {
+		return "", "", errors.New("invalid master address " + pair)
+	}
+	return host, port, nil
+}
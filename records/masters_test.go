@@ -0,0 +1,105 @@
+package records
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseMasterAddress(t *testing.T) {
+	for s, want := range map[string]MasterAddress{
+		"10.0.0.1:5050":        {Hosts: []string{"10.0.0.1:5050"}},
+		"10.0.0.1":             {Hosts: []string{"10.0.0.1:5050"}},
+		"[fd00::1]:5051":       {Hosts: []string{"[fd00::1]:5051"}},
+		"fd00::1":              {Hosts: []string{"[fd00::1]:5050"}},
+		"master.mesos:5050":    {Hosts: []string{"master.mesos:5050"}},
+		"https://10.0.0.1:443": {Scheme: "https", Hosts: []string{"10.0.0.1:443"}},
+		"http://master.mesos":  {Scheme: "http", Hosts: []string{"master.mesos:5050"}},
+		"file:///etc/masters":  {Scheme: "file", Path: "/etc/masters"},
+		"zk://10.0.0.1:2181,10.0.0.2:2181/mesos": {
+			Scheme: "zk", Hosts: []string{"10.0.0.1:2181", "10.0.0.2:2181"}, Path: "/mesos",
+		},
+		"zk://user:p@ss@[fd00::1]:2181/mesos": {
+			Scheme: "zk", Hosts: []string{"[fd00::1]:2181"}, Path: "/mesos", Username: "user", Password: "p@ss",
+		},
+	} {
+		a, err := ParseMasterAddress(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(a, want) {
+			t.Errorf("%s: got %+v, want %+v", s, a, want)
+		}
+	}
+
+	for _, s := range []string{
+		"", "10.0.0.1:", ":5050", "1:2:3:4:5:6:7:8:9", "ftp://10.0.0.1",
+		"file://relative/path", "zk://10.0.0.1:2181", "zk://10.0.0.1/mesos", "zk://user@10.0.0.1:2181/mesos",
+	} {
+		if _, err := ParseMasterAddress(s); err == nil {
+			t.Errorf("%q should not parse", s)
+		}
+	}
+}
+
+func TestMasterAddressString(t *testing.T) {
+	for _, s := range []string{"zk://user:pass@10.0.0.1:2181,10.0.0.2:2181/mesos", "file:///etc/masters"} {
+		a, _ := ParseMasterAddress(s)
+		if a.String() != s {
+			t.Errorf("%s formats as %s", s, a)
+		}
+	}
+}
+
+func TestResolveMasters(t *testing.T) {
+	f, err := ioutil.TempFile("", "masters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# masters\n10.0.0.2:5050,10.0.0.3:5050\n\nzk://10.0.0.9:2181/mesos\n")
+	f.Close()
+
+	hosts, err := ResolveMasters([]string{"10.0.0.1:5050", "file://" + f.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1:5050", "10.0.0.2:5050", "10.0.0.3:5050"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("got %v, want %v", hosts, want)
+	}
+
+	// the file is read again on each call
+	ioutil.WriteFile(f.Name(), []byte("10.0.0.4:5050\n"), 0644)
+	if hosts, _ = ResolveMasters([]string{"file://" + f.Name()}); len(hosts) != 1 || hosts[0] != "10.0.0.4:5050" {
+		t.Error("should pick up changes of master files, got ", hosts)
+	}
+
+	if _, err = ResolveMasters([]string{"file:///does/not/exist"}); err == nil {
+		t.Error("should fail for missing master files")
+	}
+}
+
+func TestCheckMasters(t *testing.T) {
+	c := Config{Masters: []string{"10.0.0.1:5050", "zk://10.0.0.9:2181/mesos"}}
+	if err := c.checkMasters(); err != nil || c.Zk != "zk://10.0.0.9:2181/mesos" {
+		t.Errorf("zk masters should become the zk field, got %q, %v", c.Zk, err)
+	}
+
+	c = Config{Masters: []string{"zk://10.0.0.9:2181/mesos"}, Zk: "zk://10.0.0.8:2181/mesos"}
+	if err := c.checkMasters(); err == nil {
+		t.Error("should reject conflicting zookeepers")
+	}
+
+	c = Config{Masters: []string{"https://10.0.0.1:5050"}}
+	if err := c.checkMasters(); err == nil {
+		t.Error("should reject https masters without mesosClient.https")
+	}
+
+	c = Config{Zk: "10.0.0.9:2181"}
+	if err := c.checkMasters(); err == nil {
+		t.Error("should reject zk fields that aren't zk addresses")
+	}
+}
@@ -0,0 +1,92 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format; snapshots of
+// other versions are not loaded
+const snapshotVersion = 1
+
+// snapshot is the persisted form of a record generation
+type snapshot struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Domain  string    `json:"domain"`
+	As      rrs       `json:"a"`
+	SRVs    rrs       `json:"srv"`
+	Slaves  Slaves    `json:"slaves"`
+}
+
+// WriteSnapshot persists the records of domain to path. The file is
+// replaced atomically, so readers see either the old or the new snapshot.
+func (rg *RecordGenerator) WriteSnapshot(path, domain string, created time.Time) error {
+	b, err := json.Marshal(snapshot{
+		Version: snapshotVersion,
+		Created: created,
+		Domain:  domain,
+		As:      rg.As,
+		SRVs:    rg.SRVs,
+		Slaves:  rg.Slaves,
+	})
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, b)
+}
+
+// writeAtomic replaces the file at path with b, so that readers see
+// either the old or the new content
+func writeAtomic(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// ReadSnapshot loads the records of domain persisted at path, along with
+// the time they were created
+func ReadSnapshot(path, domain string) (*RecordGenerator, time.Time, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var s snapshot
+	if err = json.Unmarshal(b, &s); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if s.Version != snapshotVersion {
+		return nil, time.Time{}, fmt.Errorf("%s: unsupported snapshot version %d", path, s.Version)
+	}
+	if s.Domain != domain {
+		return nil, time.Time{}, fmt.Errorf("%s: snapshot of domain %s instead of %s", path, s.Domain, domain)
+	}
+
+	rg := &RecordGenerator{As: s.As, SRVs: s.SRVs, Slaves: s.Slaves}
+	if rg.As == nil {
+		rg.As = make(rrs)
+	}
+	if rg.SRVs == nil {
+		rg.SRVs = make(rrs)
+	}
+	return rg, s.Created, nil
+}
@@ -0,0 +1,63 @@
+package records
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "records.json")
+
+	rg := &RecordGenerator{
+		As:     rrs{"web.marathon.mesos.": {"10.0.0.1"}},
+		SRVs:   rrs{"_web._tcp.marathon.mesos.": {"web-s1.marathon.mesos.:31000"}},
+		Slaves: Slaves{{Id: "s1", Hostname: "10.0.0.1", Pid: "slave(1)@10.0.0.1:5051"}},
+	}
+	created := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+	if err = rg.WriteSnapshot(path, "mesos", created); err != nil {
+		t.Fatal(err)
+	}
+
+	got, at, err := ReadSnapshot(path, "mesos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !at.Equal(created) {
+		t.Error("wrong creation time: ", at)
+	}
+	if !reflect.DeepEqual(got.As, rg.As) || !reflect.DeepEqual(got.SRVs, rg.SRVs) || !reflect.DeepEqual(got.Slaves, rg.Slaves) {
+		t.Errorf("records changed in the snapshot: %+v", got)
+	}
+
+	if _, _, err = ReadSnapshot(path, "example.com"); err == nil {
+		t.Error("should not load the snapshot of another domain")
+	}
+
+	// no temporary files are left behind
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Error("expected only the snapshot, got ", len(files), " files")
+	}
+}
+
+func TestSnapshotVersion(t *testing.T) {
+	f, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"version": 99, "domain": "mesos"}`)
+	f.Close()
+
+	if _, _, err = ReadSnapshot(f.Name(), "mesos"); err == nil {
+		t.Error("should reject unknown snapshot versions")
+	}
+}
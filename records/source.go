@@ -0,0 +1,277 @@
+package records
+
+import (
+	"errors"
+	"sort"
+)
+
+// types of the records yielded by sources
+const (
+	TypeA     = "A"
+	TypeSRV   = "SRV"
+	TypeCNAME = "CNAME"
+)
+
+// names of the sources
+const (
+	SourceMesos   = "mesos"
+	SourceZone    = "zone"
+	SourceUpdates = "updates"
+)
+
+// knownSources are the sources records can come from, in their default
+// order of precedence: static records overlay those of the tasks, which
+// overlay those added by updates
+var knownSources = []string{SourceZone, SourceMesos, SourceUpdates}
+
+// how names several sources have records for are merged
+const (
+	ConflictPrecedence = "precedence"
+	ConflictMerge      = "merge"
+)
+
+// Record is a record of the domain along with the source it comes from
+type Record struct {
+	// Name is the fully qualified name, like web.marathon.mesos.
+	Name string
+
+	// Type is TypeA, TypeSRV or TypeCNAME
+	Type string
+
+	// Target is the host or address of an A record, the host:port of an
+	// SRV record or the canonical name of a CNAME record
+	Target string
+
+	// Source is the name of the source that yielded the record
+	Source string
+}
+
+// RecordSource yields the records of a source, like the Mesos masters
+type RecordSource interface {
+	// Name identifies the source, like mesos
+	Name() string
+
+	// Records returns the current records of the source
+	Records() ([]Record, error)
+}
+
+// RecordSet are the records yielded by a source, as records or as a
+// whole generation like those of the mesos source
+type RecordSet struct {
+	Source     string
+	Records    []Record
+	Generation *RecordGenerator
+}
+
+// Len returns the number of records of the set
+func (s RecordSet) Len() int {
+	n := len(s.Records)
+	if s.Generation != nil {
+		n += s.Generation.Len()
+	}
+	return n
+}
+
+// MesosSource yields the records of the tasks known to the Mesos masters
+type MesosSource struct {
+	client *MasterClient
+	zk     *ZKDetector
+	config *Config
+	gen    *RecordGenerator
+}
+
+// NewMesosSource returns the source of the tasks known to the masters
+// of config, found with client and zk
+func NewMesosSource(client *MasterClient, zk *ZKDetector, config *Config) *MesosSource {
+	return &MesosSource{client: client, zk: zk, config: config}
+}
+
+// Name identifies the source
+func (s *MesosSource) Name() string {
+	return SourceMesos
+}
+
+// Records returns the records of the state of the leading master
+func (s *MesosSource) Records() ([]Record, error) {
+	rg, err := s.Generate()
+	if err != nil {
+		return nil, err
+	}
+	return rg.Records(SourceMesos), nil
+}
+
+// Generate returns the records and slaves of the state of the leading
+// master as generation, which is cheaper to merge than its records
+func (s *MesosSource) Generate() (*RecordGenerator, error) {
+	if s.client == nil {
+		return nil, errors.New("no client for the mesos masters")
+	}
+	rg := &RecordGenerator{}
+	if err := rg.ParseState(s.client, s.zk, s.config); err != nil {
+		return nil, err
+	}
+	s.gen = rg
+	return rg, nil
+}
+
+// Generation returns the records and slaves of the last successful call
+// to Records or Generate, nil before
+func (s *MesosSource) Generation() *RecordGenerator {
+	return s.gen
+}
+
+// Len returns the number of records of the generation
+func (rg *RecordGenerator) Len() int {
+	n := 0
+	for _, m := range []rrs{rg.As, rg.SRVs, rg.CNAMEs} {
+		for _, targets := range m {
+			n += len(targets)
+		}
+	}
+	return n
+}
+
+// Records returns the records of the generation, attributed to source
+func (rg *RecordGenerator) Records(source string) []Record {
+	recs := make([]Record, 0, len(rg.As)+len(rg.SRVs)+len(rg.CNAMEs))
+	for _, t := range []struct {
+		rtype string
+		rrs   rrs
+	}{{TypeA, rg.As}, {TypeSRV, rg.SRVs}, {TypeCNAME, rg.CNAMEs}} {
+		names := make([]string, 0, len(t.rrs))
+		for name := range t.rrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, target := range t.rrs[name] {
+				recs = append(recs, Record{Name: name, Type: t.rtype, Target: target, Source: source})
+			}
+		}
+	}
+	return recs
+}
+
+// Merge returns the generation serving the records of several sources,
+// given by precedence, highest first. Under ConflictPrecedence a name is
+// served by the first source with records for it only, under
+// ConflictMerge by every source. It also returns how many records of
+// every source were shadowed that way. The generation of the only source
+// with records is served as is.
+func Merge(sets []RecordSet, conflicts string) (*RecordGenerator, map[string]int) {
+	shadowed := make(map[string]int, len(sets))
+	var only *RecordSet
+	nonEmpty := 0
+	for i := range sets {
+		shadowed[sets[i].Source] = 0
+		if sets[i].Len() > 0 {
+			only = &sets[i]
+			nonEmpty++
+		}
+	}
+	if nonEmpty == 1 && only.Generation != nil && len(only.Records) == 0 {
+		return only.Generation, shadowed
+	}
+
+	rg := &RecordGenerator{As: make(rrs), SRVs: make(rrs), CNAMEs: make(rrs)}
+	owner := make(map[string]string)
+	// owns tells whether source may serve name, taking it if free
+	owns := func(name, source string) bool {
+		if o, ok := owner[name]; ok && o != source && conflicts != ConflictMerge {
+			return false
+		}
+		owner[name] = source
+		return true
+	}
+	for _, set := range sets {
+		if g := set.Generation; g != nil {
+			for _, t := range []struct {
+				rtype string
+				rrs   rrs
+			}{{TypeA, g.As}, {TypeSRV, g.SRVs}, {TypeCNAME, g.CNAMEs}} {
+				for name, targets := range t.rrs {
+					if owns(name, set.Source) {
+						rg.insertRRs(name, targets, t.rtype)
+					} else {
+						shadowed[set.Source] += len(targets)
+					}
+				}
+			}
+		}
+		for _, r := range set.Records {
+			if !owns(r.Name, set.Source) {
+				shadowed[set.Source]++
+				continue
+			}
+			switch r.Type {
+			case TypeA, TypeSRV, TypeCNAME:
+				rg.insertRR(r.Name, r.Target, r.Type)
+			}
+		}
+	}
+	return rg, shadowed
+}
+
+// insertRRs inserts the targets of name at once, sharing the slice when
+// name has no records yet
+func (rg *RecordGenerator) insertRRs(name string, targets []string, rtype string) {
+	m := rg.SRVs
+	switch rtype {
+	case TypeA:
+		m = rg.As
+	case TypeCNAME:
+		m = rg.CNAMEs
+	}
+	if _, ok := m[name]; !ok {
+		// capped so that appending copies instead of writing to the source
+		m[name] = targets[:len(targets):len(targets)]
+		return
+	}
+	for _, target := range targets {
+		rg.insertRR(name, target, rtype)
+	}
+}
+
+// Order returns the names of the sources ordered by precedence: those
+// listed by the configuration first, then the others in their default
+// order, then unknown ones in the order given
+func (s SourcesConfig) Order(names []string) []string {
+	available := make(map[string]bool, len(names))
+	for _, n := range names {
+		available[n] = true
+	}
+	ordered := make([]string, 0, len(names))
+	for _, list := range [][]string{s.Precedence, knownSources, names} {
+		for _, n := range list {
+			if available[n] {
+				ordered = append(ordered, n)
+				delete(available, n)
+			}
+		}
+	}
+	return ordered
+}
+
+// check checks the names and the conflict policy of the sources
+func (s SourcesConfig) check() ConfigErrors {
+	var errs ConfigErrors
+	known := make(map[string]bool, len(knownSources))
+	for _, n := range knownSources {
+		known[n] = true
+	}
+	seen := make(map[string]bool, len(s.Precedence))
+	for _, n := range s.Precedence {
+		if !known[n] {
+			errs.add("sources.precedence", "unknown source %q", n)
+		} else if seen[n] {
+			errs.add("sources.precedence", "source %q is listed twice", n)
+		}
+		seen[n] = true
+	}
+	switch s.Conflicts {
+	case ConflictPrecedence, ConflictMerge:
+	default:
+		errs.add("sources.conflicts", "unknown conflict policy %q", s.Conflicts)
+	}
+	return errs
+}
@@ -0,0 +1,103 @@
+package records
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	sets := []RecordSet{
+		{Source: "mesos", Records: []Record{
+			{Name: "web.marathon.mesos.", Type: TypeA, Target: "10.0.0.1", Source: "mesos"},
+			{Name: "_web._tcp.marathon.mesos.", Type: TypeSRV, Target: "web.marathon.mesos.:31000", Source: "mesos"},
+		}},
+		{Source: "static", Records: []Record{
+			{Name: "web.marathon.mesos.", Type: TypeA, Target: "10.0.0.2", Source: "static"},
+			{Name: "db.mesos.", Type: TypeA, Target: "10.0.0.3", Source: "static"},
+		}},
+	}
+
+	rg, shadowed := Merge(sets, ConflictPrecedence)
+	if got := rg.As["web.marathon.mesos."]; !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+		t.Error("the source of highest precedence should own a name, got ", got)
+	}
+	if len(rg.As["db.mesos."]) != 1 || len(rg.SRVs["_web._tcp.marathon.mesos."]) != 1 {
+		t.Errorf("names without conflicts should be served, got %v %v", rg.As, rg.SRVs)
+	}
+	if shadowed["static"] != 1 || shadowed["mesos"] != 0 {
+		t.Error("should count shadowed records, got ", shadowed)
+	}
+
+	rg, shadowed = Merge(sets, ConflictMerge)
+	if got := rg.As["web.marathon.mesos."]; !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Error("merged names should be served by every source, got ", got)
+	}
+	if shadowed["static"] != 0 {
+		t.Error("merged records aren't shadowed, got ", shadowed)
+	}
+}
+
+func TestMergeGeneration(t *testing.T) {
+	mesos := &RecordGenerator{
+		As:   rrs{"web.marathon.mesos.": {"10.0.0.1"}, "db.mesos.": {"10.0.0.4"}},
+		SRVs: rrs{"_web._tcp.marathon.mesos.": {"web.marathon.mesos.:31000"}},
+	}
+	empty := RecordSet{Source: "zone"}
+	if rg, _ := Merge([]RecordSet{empty, {Source: "mesos", Generation: mesos}}, ConflictPrecedence); rg != mesos {
+		t.Error("the generation of the only source with records should be served as is")
+	}
+
+	zone := RecordSet{Source: "zone", Records: []Record{
+		{Name: "db.mesos.", Type: TypeA, Target: "10.0.0.3", Source: "zone"},
+	}}
+	updates := RecordSet{Source: "updates", Records: []Record{
+		{Name: "web.marathon.mesos.", Type: TypeA, Target: "10.0.0.2", Source: "updates"},
+	}}
+	sets := []RecordSet{zone, {Source: "mesos", Generation: mesos}, updates}
+
+	rg, shadowed := Merge(sets, ConflictPrecedence)
+	if got := rg.As["db.mesos."]; !reflect.DeepEqual(got, []string{"10.0.0.3"}) {
+		t.Error("the source of highest precedence should own a name, got ", got)
+	}
+	if len(rg.As["web.marathon.mesos."]) != 1 || len(rg.SRVs["_web._tcp.marathon.mesos."]) != 1 {
+		t.Errorf("names without conflicts should be served, got %v %v", rg.As, rg.SRVs)
+	}
+	if shadowed["mesos"] != 1 || shadowed["updates"] != 1 {
+		t.Error("should count shadowed records, got ", shadowed)
+	}
+
+	rg, _ = Merge(sets, ConflictMerge)
+	if got := rg.As["web.marathon.mesos."]; !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Error("merged names should be served by every source, got ", got)
+	}
+	if got := mesos.As["web.marathon.mesos."]; !reflect.DeepEqual(got, []string{"10.0.0.1"}) {
+		t.Error("merging should not change the generation, got ", got)
+	}
+}
+
+func TestGeneratorRecords(t *testing.T) {
+	rg := &RecordGenerator{
+		As:   rrs{"b.mesos.": {"10.0.0.2"}, "a.mesos.": {"10.0.0.1"}},
+		SRVs: rrs{"_a._tcp.mesos.": {"a.mesos.:80"}},
+	}
+	want := []Record{
+		{"a.mesos.", TypeA, "10.0.0.1", "mesos"},
+		{"b.mesos.", TypeA, "10.0.0.2", "mesos"},
+		{"_a._tcp.mesos.", TypeSRV, "a.mesos.:80", "mesos"},
+	}
+	if got := rg.Records("mesos"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestSourcesOrder(t *testing.T) {
+	s := SourcesConfig{Precedence: []string{"zone", "missing", "mesos"}}
+	if got := s.Order([]string{"mesos", "updates", "zone"}); !reflect.DeepEqual(got, []string{"zone", "mesos", "updates"}) {
+		t.Error("listed sources should come first, got ", got)
+	}
+
+	errs := SourcesConfig{Precedence: []string{"mesos", "mesos", "marathon"}, Conflicts: "last"}.check()
+	if len(errs) != 3 {
+		t.Error("should report duplicate and unknown sources and the policy, got ", errs)
+	}
+}
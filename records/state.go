@@ -0,0 +1,150 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DecodeState decodes a state.json from r as a stream. Only the fields
+// the generator uses are materialized; everything else, such as completed
+// tasks and frameworks, is skipped token by token, so memory use doesn't
+// grow with the size of the history kept by the master.
+func DecodeState(r io.Reader) (StateJSON, error) {
+	var sj StateJSON
+	d := json.NewDecoder(r)
+
+	err := decodeObject(d, func(key string) error {
+		switch key {
+		case "leader":
+			return d.Decode(&sj.Leader)
+		case "pid":
+			return d.Decode(&sj.Pid)
+		case "slaves":
+			return decodeArray(d, func() error {
+				var s slave
+				if err := d.Decode(&s); err != nil {
+					return err
+				}
+				sj.Slaves = append(sj.Slaves, s)
+				return nil
+			})
+		case "frameworks":
+			return decodeArray(d, func() error {
+				f, err := decodeFramework(d)
+				if err == nil {
+					sj.Frameworks = append(sj.Frameworks, f)
+				}
+				return err
+			})
+		}
+		return skipValue(d)
+	})
+	return sj, err
+}
+
+// decodeFramework decodes the name and the running tasks of a framework
+func decodeFramework(d *json.Decoder) (framework, error) {
+	var f framework
+	err := decodeObject(d, func(key string) error {
+		switch key {
+		case "name":
+			return d.Decode(&f.Name)
+		case "tasks":
+			return decodeArray(d, func() error {
+				var t task
+				if err := d.Decode(&t); err != nil {
+					return err
+				}
+				f.Tasks = append(f.Tasks, t)
+				return nil
+			})
+		}
+		return skipValue(d)
+	})
+	return f, err
+}
+
+// decodeObject calls member for the key of each member of the next
+// object, which must consume the value; null counts as an empty object
+func decodeObject(d *json.Decoder, member func(key string) error) error {
+	t, err := d.Token()
+	if err != nil || t == nil {
+		return err
+	}
+	if t != json.Delim('{') {
+		return fmt.Errorf("expected an object, got %v", t)
+	}
+	for d.More() {
+		t, err = d.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("expected a key, got %v", t)
+		}
+		if err = member(key); err != nil {
+			return err
+		}
+	}
+	_, err = d.Token()
+	return err
+}
+
+// decodeArray calls elem for each element of the next array, which must
+// consume the element; null counts as an empty array
+func decodeArray(d *json.Decoder, elem func() error) error {
+	t, err := d.Token()
+	if err != nil || t == nil {
+		return err
+	}
+	if t != json.Delim('[') {
+		return fmt.Errorf("expected an array, got %v", t)
+	}
+	for d.More() {
+		if err = elem(); err != nil {
+			return err
+		}
+	}
+	_, err = d.Token()
+	return err
+}
+
+// ignored unmarshals any value into nothing
+type ignored struct{}
+
+func (*ignored) UnmarshalJSON([]byte) error { return nil }
+
+// skipValue consumes the next value without materializing it. Arrays and
+// objects are skipped one element at a time, so only a single element is
+// ever buffered.
+func skipValue(d *json.Decoder) error {
+	t, err := d.Token()
+	if err != nil {
+		return err
+	}
+
+	var skip ignored
+	switch t {
+	case json.Delim('['):
+		for d.More() {
+			if err = d.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	case json.Delim('{'):
+		for d.More() {
+			if _, err = d.Token(); err != nil {
+				return err
+			}
+			if err = d.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	_, err = d.Token()
+	return err
+}
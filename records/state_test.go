@@ -0,0 +1,248 @@
+package records
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syntheticState returns a state.json of a cluster with the given number
+// of slaves and frameworks, each framework running tasks tasks and
+// remembering completed ones
+func syntheticState(slaves, frameworks, tasks, completed int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"version": "0.22.1", "pid": "master@10.0.0.1:5050", "leader": "master@10.0.0.1:5050", "slaves": [`)
+	for s := 0; s < slaves; s++ {
+		if s > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id": "20150101-0000-S%d", "hostname": "slave%d.example.com", "pid": "slave(1)@10.1.%d.%d:5051",`+
+			` "resources": {"cpus": 16, "mem": 64000, "ports": "[31000-32000]"}, "attributes": {"rack": "r%d"}}`,
+			s, s, s/250, s%250, s%40)
+	}
+	b.WriteString(`], "frameworks": [`)
+
+	writeTask := func(f, t int, state string) {
+		fmt.Fprintf(&b, `{"id": "task%d.%d", "name": "app%d", "framework_id": "fw%d", "slave_id": "20150101-0000-S%d",`+
+			` "state": "%s", "resources": {"cpus": 0.5, "mem": 256, "ports": "[%d-%d]"},`+
+			` "statuses": [{"state": "TASK_STARTING", "timestamp": 1430000000.1}, {"state": "%s", "timestamp": 1430000001.2}],`+
+			` "labels": [{"key": "owner", "value": "team%d"}]}`,
+			f, t, t%500, f, (f*tasks+t)%slaves, state, 31000+t%900, 31001+t%900, state, f)
+	}
+	for f := 0; f < frameworks; f++ {
+		if f > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id": "fw%d", "name": "framework%d", "active": true, "tasks": [`, f, f)
+		for t := 0; t < tasks; t++ {
+			if t > 0 {
+				b.WriteByte(',')
+			}
+			writeTask(f, t, "TASK_RUNNING")
+		}
+		b.WriteString(`], "completed_tasks": [`)
+		for t := 0; t < completed; t++ {
+			if t > 0 {
+				b.WriteByte(',')
+			}
+			writeTask(f, t, "TASK_FINISHED")
+		}
+		b.WriteString(`]}`)
+	}
+	b.WriteString(`], "completed_frameworks": [{"id": "old", "name": "old", "tasks": [], "completed_tasks": [{"id": "x"}]}]}`)
+	return b.Bytes()
+}
+
+func TestDecodeState(t *testing.T) {
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal("missing test data")
+	}
+
+	var want StateJSON
+	if err = json.Unmarshal(b, &want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeState(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same records are generated either way
+	masters := []string{"144.76.157.37:5050"}
+	rgWant, rgGot := RecordGenerator{}, RecordGenerator{}
+	rgWant.InsertState(want, "mesos", "mesos-dns.mesos.", []string{"127.0.0.1"}, masters)
+	rgGot.InsertState(got, "mesos", "mesos-dns.mesos.", []string{"127.0.0.1"}, masters)
+	if !reflect.DeepEqual(rgGot.As, rgWant.As) || !reflect.DeepEqual(rgGot.SRVs, rgWant.SRVs) {
+		t.Error("streaming decoding should generate the same records as unmarshaling")
+	}
+}
+
+func TestDecodeStateSkipsHistory(t *testing.T) {
+	sj, err := DecodeState(bytes.NewReader(syntheticState(3, 2, 4, 5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sj.Leader != "master@10.0.0.1:5050" || sj.Pid != sj.Leader {
+		t.Error("wrong leader: ", sj.Leader, sj.Pid)
+	}
+	if len(sj.Slaves) != 3 || sj.Slaves[1].Hostname != "slave1.example.com" {
+		t.Error("wrong slaves: ", sj.Slaves)
+	}
+	if len(sj.Frameworks) != 2 {
+		t.Fatal("completed frameworks should be skipped, got ", len(sj.Frameworks))
+	}
+	for _, f := range sj.Frameworks {
+		if len(f.Tasks) != 4 {
+			t.Error("completed tasks should be skipped, got ", len(f.Tasks))
+		}
+	}
+}
+
+func TestDecodeStateErrors(t *testing.T) {
+	if sj, err := DecodeState(strings.NewReader(`{"frameworks": null, "slaves": null}`)); err != nil || len(sj.Frameworks) != 0 {
+		t.Error("null should decode as empty: ", err)
+	}
+	for _, s := range []string{``, `[]`, `{"slaves": {}}`, `{"frameworks": [{"tasks": [`, `{"leader": 1}`} {
+		if _, err := DecodeState(strings.NewReader(s)); err == nil {
+			t.Errorf("%q should not decode", s)
+		}
+	}
+}
+
+func TestMasterClientGzip(t *testing.T) {
+	ts, host, port := fakeMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Error("should accept gzip")
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write(syntheticState(2, 1, 3, 3))
+		gz.Close()
+	})
+	defer ts.Close()
+
+	mc, _ := NewMasterClient(MesosClientConfig{ConnectTimeout: 1, ReadTimeout: 1})
+	sj, err := mc.State(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sj.Frameworks) != 1 || len(sj.Frameworks[0].Tasks) != 3 {
+		t.Error("not decoding gzipped state")
+	}
+}
+
+var (
+	largeState     []byte
+	largeStateOnce sync.Once
+)
+
+// benchState is a 2,000 slave cluster running 10,000 tasks that remembers
+// 100,000 completed ones
+func benchState() []byte {
+	largeStateOnce.Do(func() { largeState = syntheticState(2000, 50, 200, 2000) })
+	return largeState
+}
+
+// peakHeap runs f and reports the peak growth of the heap while it ran
+func peakHeap(b *testing.B, f func()) {
+	runtime.GC()
+	var base runtime.MemStats
+	runtime.ReadMemStats(&base)
+
+	done := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		var max uint64
+		var ms runtime.MemStats
+		tick := time.NewTicker(time.Millisecond)
+		defer tick.Stop()
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > max {
+				max = ms.HeapInuse
+			}
+			select {
+			case <-done:
+				peak <- max
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+
+	f()
+	close(done)
+	var grown uint64
+	if p := <-peak; p > base.HeapInuse {
+		grown = p - base.HeapInuse
+	}
+	b.ReportMetric(float64(grown)/(1<<20), "peak-MB")
+}
+
+func BenchmarkDecodeState(b *testing.B) {
+	state := benchState()
+	b.SetBytes(int64(len(state)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	peakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			if _, err := DecodeState(bytes.NewReader(state)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkUnmarshalState decodes like before streaming, for comparison
+func BenchmarkUnmarshalState(b *testing.B) {
+	state := benchState()
+	b.SetBytes(int64(len(state)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	peakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			body, err := ioutil.ReadAll(bytes.NewReader(state))
+			if err != nil {
+				b.Fatal(err)
+			}
+			var sj StateJSON
+			if err = json.Unmarshal(body, &sj); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeStateGzip(b *testing.B) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(benchState())
+	gz.Close()
+	b.SetBytes(int64(len(benchState())))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	peakHeap(b, func() {
+		for i := 0; i < b.N; i++ {
+			r, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err = DecodeState(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
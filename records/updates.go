@@ -0,0 +1,206 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// updatesVersion is the version of the format of the update store; stores
+// of other versions are not loaded
+const updatesVersion = 1
+
+// UpdateStore holds the records added by dynamic updates, each served
+// until its lease expires unless added again. Every change is persisted
+// to the store file, if there is one.
+type UpdateStore struct {
+	path string
+
+	sync.Mutex
+	leases map[Record]time.Time // expiry of every record
+}
+
+// UpdateOp is a change of an update: Record added for Lease, or unless
+// Delete the records matching Record deleted, an empty Type or Target
+// matching any
+type UpdateOp struct {
+	Delete bool
+	Record Record
+	Lease  time.Duration
+}
+
+// storedUpdates is the persisted form of the store
+type storedUpdates struct {
+	Version int            `json:"version"`
+	Records []storedRecord `json:"records"`
+}
+
+// storedRecord is the persisted form of a record and its lease
+type storedRecord struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Target  string    `json:"target"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewUpdateStore returns the store persisted at path, empty if the file
+// doesn't exist yet. An empty path keeps the records in memory only.
+func NewUpdateStore(path string) (*UpdateStore, error) {
+	s := &UpdateStore{path: path, leases: make(map[Record]time.Time)}
+	if path == "" {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stored storedUpdates
+	if err = json.Unmarshal(b, &stored); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if stored.Version != updatesVersion {
+		return nil, fmt.Errorf("%s: unsupported version %d", path, stored.Version)
+	}
+	for _, r := range stored.Records {
+		s.leases[Record{r.Name, r.Type, r.Target, SourceUpdates}] = r.Expires
+	}
+	return s, nil
+}
+
+// Name identifies the source
+func (s *UpdateStore) Name() string {
+	return SourceUpdates
+}
+
+// Records returns the records whose lease hasn't expired, dropping the
+// others
+func (s *UpdateStore) Records() ([]Record, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	expired := false
+	recs := make([]Record, 0, len(s.leases))
+	for r, expires := range s.leases {
+		if !expires.After(now) {
+			delete(s.leases, r)
+			expired = true
+			continue
+		}
+		recs = append(recs, r)
+	}
+	if expired {
+		if err := s.save(s.leases); err != nil {
+			logging.Error.Println("cannot persist updates: ", err)
+		}
+	}
+	sort.Sort(byRecord(recs))
+	return recs, nil
+}
+
+// Changed tells whether a lease expired since the records were last got
+func (s *UpdateStore) Changed() bool {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for _, expires := range s.leases {
+		if !expires.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply applies the ops of an update in order at now. As in RFC 2136, a
+// CNAME record isn't added to a name with other records, nor other
+// records to a name with a CNAME record, and a CNAME record replaces the
+// previous one. Nothing is applied unless the result can be persisted.
+func (s *UpdateStore) Apply(ops []UpdateOp, now time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	leases := make(map[Record]time.Time, len(s.leases))
+	for r, expires := range s.leases {
+		leases[r] = expires
+	}
+	for _, op := range ops {
+		r := op.Record
+		r.Source = SourceUpdates
+		if op.Delete {
+			for l := range leases {
+				if l.Name == r.Name && (r.Type == "" || l.Type == r.Type) && (r.Target == "" || l.Target == r.Target) {
+					delete(leases, l)
+				}
+			}
+			continue
+		}
+
+		conflict := false
+		for l := range leases {
+			if l.Name != r.Name || l == r {
+				continue
+			}
+			switch {
+			case l.Type == TypeCNAME && r.Type == TypeCNAME:
+				delete(leases, l)
+			case l.Type == TypeCNAME || r.Type == TypeCNAME:
+				conflict = true
+			}
+		}
+		if !conflict {
+			leases[r] = now.Add(op.Lease)
+		}
+	}
+
+	if err := s.save(leases); err != nil {
+		return err
+	}
+	s.leases = leases
+	return nil
+}
+
+// save persists leases to the store file, if there is one
+func (s *UpdateStore) save(leases map[Record]time.Time) error {
+	if s.path == "" {
+		return nil
+	}
+	stored := storedUpdates{Version: updatesVersion, Records: make([]storedRecord, 0, len(leases))}
+	recs := make([]Record, 0, len(leases))
+	for r := range leases {
+		recs = append(recs, r)
+	}
+	sort.Sort(byRecord(recs))
+	for _, r := range recs {
+		stored.Records = append(stored.Records, storedRecord{r.Name, r.Type, r.Target, leases[r]})
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(s.path, b)
+}
+
+// byRecord sorts records by name, type and target
+type byRecord []Record
+
+func (rs byRecord) Len() int      { return len(rs) }
+func (rs byRecord) Swap(i, j int) { rs[i], rs[j] = rs[j], rs[i] }
+func (rs byRecord) Less(i, j int) bool {
+	a, b := rs[i], rs[j]
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	return a.Target < b.Target
+}
@@ -0,0 +1,62 @@
+package records
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUpdateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "updates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "updates.json")
+
+	s, err := NewUpdateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	add := func(name, rtype, target string, lease time.Duration) UpdateOp {
+		return UpdateOp{Record: Record{Name: name, Type: rtype, Target: target}, Lease: lease}
+	}
+	err = s.Apply([]UpdateOp{
+		add("a.mesos.", TypeA, "10.0.0.1", time.Hour),
+		add("a.mesos.", TypeA, "10.0.0.2", time.Hour),
+		add("a.mesos.", TypeCNAME, "b.mesos.", time.Hour),
+		add("db.mesos.", TypeCNAME, "old.example.com.", time.Hour),
+		add("db.mesos.", TypeCNAME, "db.example.com.", time.Hour),
+		add("old.mesos.", TypeA, "10.0.0.3", -time.Second),
+		{Delete: true, Record: Record{Name: "a.mesos.", Target: "10.0.0.2"}},
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Changed() {
+		t.Error("should have changed once a lease expired")
+	}
+	want := []Record{
+		{"a.mesos.", TypeA, "10.0.0.1", SourceUpdates},
+		{"db.mesos.", TypeCNAME, "db.example.com.", SourceUpdates},
+	}
+	if recs, _ := s.Records(); !reflect.DeepEqual(recs, want) {
+		t.Errorf("want %v, got %v", want, recs)
+	}
+	if s.Changed() {
+		t.Error("should not have changed")
+	}
+
+	s, err = NewUpdateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs, _ := s.Records(); !reflect.DeepEqual(recs, want) {
+		t.Errorf("should load the persisted records %v, got %v", want, recs)
+	}
+}
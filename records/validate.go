@@ -0,0 +1,307 @@
+package records
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigError is a problem with a field of the configuration
+type ConfigError struct {
+	// Field is the path of the field, like mesosClient.readTimeout
+	Field string
+	Msg   string
+}
+
+func (e ConfigError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// ConfigErrors are all the problems found in a configuration
+type ConfigErrors []ConfigError
+
+func (es ConfigErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// add adds a problem with field
+func (es *ConfigErrors) add(field, format string, args ...interface{}) {
+	*es = append(*es, ConfigError{Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+// validate checks every field of the configuration, returning all the
+// problems found
+func (c *Config) validate() ConfigErrors {
+	var errs ConfigErrors
+
+	if len(c.Masters) == 0 && c.Zk == "" {
+		errs.add("masters", "specify mesos masters or zookeeper")
+	}
+	errs = append(errs, c.checkMasters()...)
+
+	checkPort(&errs, "port", c.Port)
+	checkPort(&errs, "httpPort", c.HTTPPort)
+	errs = append(errs, c.checkListeners()...)
+	if err := validDomain(c.Domain); err != nil {
+		errs.add("domain", "%v", err)
+	}
+	if c.Email == "" {
+		errs.add("email", "must not be empty")
+	}
+	checkNotNegative(&errs, "ttl", c.TTL)
+	checkNotNegative(&errs, "timeout", c.Timeout)
+	checkNotNegative(&errs, "configPollSeconds", c.ConfigPollSeconds)
+	checkNotNegative(&errs, "shutdownSeconds", c.ShutdownSeconds)
+
+	mc := c.MesosClient
+	checkPositive(&errs, "mesosClient.connectTimeout", mc.ConnectTimeout)
+	checkPositive(&errs, "mesosClient.readTimeout", mc.ReadTimeout)
+	checkNotNegative(&errs, "mesosClient.retries", mc.Retries)
+	checkNotNegative(&errs, "mesosClient.retryBackoffMillis", mc.RetryBackoffMillis)
+
+	checkPositive(&errs, "refreshSeconds", c.RefreshSeconds)
+	checkNotNegative(&errs, "refresh.debounceMillis", c.Refresh.DebounceMillis)
+	checkNotNegative(&errs, "refresh.minIntervalSeconds", c.Refresh.MinIntervalSeconds)
+
+	switch c.Stale.Policy {
+	case StaleServe, StaleTTL, StaleServfail:
+	default:
+		errs.add("stale.policy", "unknown policy %q", c.Stale.Policy)
+	}
+	checkNotNegative(&errs, "stale.maxSeconds", c.Stale.MaxSeconds)
+	checkNotNegative(&errs, "stale.floorTTL", c.Stale.FloorTTL)
+	checkNotNegative(&errs, "health.maxAgeSeconds", c.Health.MaxAgeSeconds)
+	errs = append(errs, c.Sources.check()...)
+	errs = append(errs, c.Updates.check()...)
+
+	if c.QueryLog.SampleRate < 0 || c.QueryLog.SampleRate > 1 {
+		errs.add("queryLog.sampleRate", "must be between 0 and 1")
+	}
+
+	rl := c.RateLimit
+	if rl.QPS < 0 || rl.ResponsesPerSecond < 0 || rl.HTTPQPS < 0 {
+		errs.add("rateLimit", "rates must not be negative")
+	}
+	checkNotNegative(&errs, "rateLimit.slip", rl.Slip)
+	if rl.IPv4PrefixLen < 0 || rl.IPv4PrefixLen > 32 {
+		errs.add("rateLimit.ipv4PrefixLen", "must be between 0 and 32")
+	}
+	if rl.IPv6PrefixLen < 0 || rl.IPv6PrefixLen > 128 {
+		errs.add("rateLimit.ipv6PrefixLen", "must be between 0 and 128")
+	}
+
+	type networks struct {
+		field string
+		cidrs []string
+	}
+	nets := []networks{
+		{"acl.mesos", c.ACL.Mesos},
+		{"acl.recursion", c.ACL.Recursion},
+		{"acl.transfer", c.ACL.Transfer},
+		{"acl.http", c.ACL.HTTP},
+		{"rateLimit.exempt", rl.Exempt},
+		{"ecsForwarders", c.ECSForwarders},
+	}
+	views := make(map[string]bool, len(c.Views))
+	for i, v := range c.Views {
+		field := fmt.Sprintf("views[%d]", i)
+		if v.Name == "" || views[v.Name] {
+			errs.add(field+".name", "every view needs a unique name")
+		}
+		views[v.Name] = true
+		if err := ValidAddressPolicy(v.AddressPolicy); err != nil {
+			errs.add(field+".addressPolicy", "%v", err)
+		}
+		nets = append(nets, networks{field + ".clients", v.Clients})
+	}
+	for _, n := range nets {
+		for _, cidr := range n.cidrs {
+			if _, err := ParseCIDR(cidr); err != nil {
+				errs.add(n.field, "%v", err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkListeners checks the DNS listeners, which must not bind the same
+// socket twice
+func (c Config) checkListeners() ConfigErrors {
+	var errs ConfigErrors
+	type socket struct {
+		proto string
+		port  int
+	}
+	bound := make(map[socket][]net.IP)
+	for i, l := range c.DNSListeners() {
+		field := fmt.Sprintf("listeners[%d]", i)
+		ip := net.ParseIP(l.Address)
+		if ip == nil {
+			errs.add(field+".address", "%q is not an IP address", l.Address)
+		}
+		if len(c.Listeners) > 0 && c.Listeners[i].Port != 0 {
+			checkPort(&errs, field+".port", l.Port)
+		}
+		for _, p := range l.Protocols {
+			if p != "udp" && p != "tcp" {
+				errs.add(field+".protocols", "unknown protocol %q", p)
+				continue
+			}
+			if ip == nil {
+				continue
+			}
+			// a wildcard address binds every address
+			s := socket{p, l.Port}
+			for _, other := range bound[s] {
+				if other.Equal(ip) || other.IsUnspecified() || ip.IsUnspecified() {
+					errs.add(field, "%s %s is bound by another listener", p, l.Addr())
+					break
+				}
+			}
+			bound[s] = append(bound[s], ip)
+		}
+		for _, r := range l.Roles {
+			if r != RoleAuthoritative && r != RoleRecursion {
+				errs.add(field+".roles", "unknown role %q", r)
+			}
+		}
+	}
+	return errs
+}
+
+// checkPort checks that port is a valid port number
+func checkPort(errs *ConfigErrors, field string, port int) {
+	if port < 1 || port > 65535 {
+		errs.add(field, "%d is not a port between 1 and 65535", port)
+	}
+}
+
+// checkPositive checks that n is greater than zero
+func checkPositive(errs *ConfigErrors, field string, n int) {
+	if n <= 0 {
+		errs.add(field, "must be positive")
+	}
+}
+
+// checkNotNegative checks that n is zero or greater
+func checkNotNegative(errs *ConfigErrors, field string, n int) {
+	if n < 0 {
+		errs.add(field, "must not be negative")
+	}
+}
+
+// validDomain checks the syntax of a domain name, without trailing dot
+func validDomain(domain string) error {
+	if domain == "" {
+		return errors.New("must not be empty")
+	}
+	if len(domain) > 253 {
+		return fmt.Errorf("%q is longer than 253 characters", domain)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("%q has an empty label or one longer than 63 characters", domain)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%q has a label starting or ending with '-'", domain)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("%q has an invalid character %q", domain, r)
+			}
+		}
+	}
+	return nil
+}
+
+// unknownKeys returns the keys of the json object b that match no field
+// of the struct type t, including those of nested objects. Like
+// encoding/json, keys match fields regardless of case.
+func unknownKeys(b []byte, t reflect.Type, prefix string) []string {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var unknown []string
+	for _, key := range keys {
+		field, ok := fieldByKey(t, key)
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		switch ft := field.Type; {
+		case ft.Kind() == reflect.Struct:
+			unknown = append(unknown, unknownKeys(obj[key], ft, prefix+key+".")...)
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			var elems []json.RawMessage
+			if json.Unmarshal(obj[key], &elems) == nil {
+				for i, elem := range elems {
+					unknown = append(unknown, unknownKeys(elem, ft.Elem(), fmt.Sprintf("%s%s[%d].", prefix, key, i))...)
+				}
+			}
+		}
+	}
+	return unknown
+}
+
+// fieldByKey returns the exported field of the struct type t a json key
+// decodes into
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Anonymous {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// check checks the keys and the lease of the updates
+func (u UpdatesConfig) check() ConfigErrors {
+	var errs ConfigErrors
+	names := make(map[string]bool, len(u.Keys))
+	for i, k := range u.Keys {
+		field := fmt.Sprintf("updates.keys[%d]", i)
+		name := tsigName(k.Name)
+		if k.Name == "" {
+			errs.add(field+".name", "must not be empty")
+		} else if names[name] {
+			errs.add(field+".name", "key %q is listed twice", k.Name)
+		}
+		names[name] = true
+		if _, ok := tsigAlgorithms[k.Algorithm]; !ok && k.Algorithm != "" {
+			errs.add(field+".algorithm", "unknown algorithm %q", k.Algorithm)
+		}
+		if b, err := base64.StdEncoding.DecodeString(k.Secret); err != nil || len(b) == 0 {
+			errs.add(field+".secret", "must be a base64 encoded secret")
+		}
+	}
+	checkPositive(&errs, "updates.leaseSeconds", u.LeaseSeconds)
+	return errs
+}
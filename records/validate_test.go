@@ -0,0 +1,103 @@
+package records
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := writeConfig(t, `{"masters": ["10.0.0.1:5050", "zk://"], "resolvers": ["8.8.8.8"],
+		"port": 70000, "domain": "-mesos", "email": "", "timeout": -1, "ttl": "60",
+		"mesosClient": {"readTimeout": -1, "retrys": 3}, "views": [{"name": "a", "adress": "ip"}], "refresh_seconds": 5}`)
+	defer os.Remove(path)
+
+	_, err := LoadConfig(path)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatal("should return configuration errors, got ", err)
+	}
+
+	fields := map[string]bool{}
+	for _, e := range errs {
+		fields[strings.ToLower(e.Field)] = true
+	}
+	for _, f := range []string{"masters", "port", "domain", "email", "timeout", "ttl", "mesosclient.readtimeout",
+		"mesosclient.retrys", "views[0].adress", "refresh_seconds"} {
+		if !fields[f] {
+			t.Errorf("should report %s, got %v", f, errs)
+		}
+	}
+}
+
+func TestCheckListeners(t *testing.T) {
+	c := Config{Listener: "0.0.0.0", Port: 53, Listeners: []ListenerConfig{
+		{Address: "127.0.0.1", Protocols: []string{"udp"}},
+		{Address: "10.0.0.1", Port: 5353, Roles: []string{"authoritative"}},
+		{Address: "10.0.0.1", Port: 5353, Protocols: []string{"tcp"}},
+		{Address: "0.0.0.0", Protocols: []string{"udp", "sctp"}},
+		{Address: "eth0", Port: 70000, Roles: []string{"forward"}},
+	}}
+
+	var got []string
+	for _, e := range c.checkListeners() {
+		got = append(got, e.Field)
+	}
+	want := []string{"listeners[2]", "listeners[3]", "listeners[3].protocols", "listeners[4].address",
+		"listeners[4].port", "listeners[4].roles"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("should report %v, got %v", want, got)
+	}
+
+	if errs := (Config{Listener: "0.0.0.0", Port: 53}).checkListeners(); len(errs) != 0 {
+		t.Error("the default listener should be valid, got ", errs)
+	}
+}
+
+func TestCheckUpdates(t *testing.T) {
+	u := UpdatesConfig{LeaseSeconds: 0, Keys: []TSIGKey{
+		{Name: "update.", Secret: "c2VjcmV0"},
+		{Name: "update", Algorithm: "hmac-sha512", Secret: "c2VjcmV0"},
+		{Secret: "not base64"},
+	}}
+
+	var got []string
+	for _, e := range u.check() {
+		got = append(got, e.Field)
+	}
+	want := []string{"updates.keys[1].name", "updates.keys[1].algorithm", "updates.keys[2].name",
+		"updates.keys[2].secret", "updates.leaseSeconds"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("should report %v, got %v", want, got)
+	}
+
+	if alg := u.Algorithm("UPDATE."); alg != "hmac-sha256." {
+		t.Error("keys should default to hmac-sha256, got ", alg)
+	}
+	if secrets := (UpdatesConfig{Keys: []TSIGKey{{Name: "Update.Mesos", Secret: "c2VjcmV0"}}}).Secrets(); secrets["update.mesos."] == "" || secrets["Update.Mesos."] == "" {
+		t.Error("secrets should be found by the name in lower case or as configured, got ", secrets)
+	}
+}
+
+func TestValidDomain(t *testing.T) {
+	for _, d := range []string{"mesos", "dc-1.mesos", "a.b.c"} {
+		if err := validDomain(d); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, d := range []string{"", "mesos.", "a..b", "-a.mesos", "a-.mesos", "a_b.mesos", strings.Repeat("a", 64)} {
+		if validDomain(d) == nil {
+			t.Errorf("%q should be invalid", d)
+		}
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	b := []byte(`{"TTL": 5, "acl": {"MESOS": [], "dns": []}, "views": [{"name": "a"}, {"nmae": "b"}], "foo": 1}`)
+	got := unknownKeys(b, reflect.TypeOf(Config{}), "")
+	want := []string{"acl.dns", "foo", "views[1].nmae"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unknown keys %v, want %v", got, want)
+	}
+}
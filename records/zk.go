@@ -0,0 +1,295 @@
+package records
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	// masters register as sequential znodes with these prefixes: older
+	// masters write a protobuf MasterInfo, newer ones its JSON form
+	zkInfoPrefix     = "info_"
+	zkJSONInfoPrefix = "json.info_"
+
+	zkSessionTimeout = 10 * time.Second
+	zkRetryInterval  = time.Second
+)
+
+// zkConn is the part of a Zookeeper connection the detector uses
+type zkConn interface {
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Close()
+}
+
+// ZKDetector watches the masters registered in Zookeeper. The master with
+// the lowest sequence number leads.
+type ZKDetector struct {
+	sync.RWMutex
+	leader  string
+	masters []string
+	changes chan struct{}
+	done    chan struct{}
+	stop    sync.Once
+}
+
+// NewZKDetector returns a detector that doesn't know any master yet
+func NewZKDetector() *ZKDetector {
+	return &ZKDetector{
+		changes: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Changes returns a channel that receives a value after the leader
+// changed; changes happening before it is received are coalesced. A nil
+// detector never changes.
+func (d *ZKDetector) Changes() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.changes
+}
+
+// Leader returns the host:port of the leading master, or "" if there is
+// none. A nil detector knows no leader.
+func (d *ZKDetector) Leader() string {
+	if d == nil {
+		return ""
+	}
+	d.RLock()
+	defer d.RUnlock()
+	return d.leader
+}
+
+// Masters returns the host:port of every registered master, ordered by
+// their sequence numbers so the leader comes first
+func (d *ZKDetector) Masters() []string {
+	if d == nil {
+		return nil
+	}
+	d.RLock()
+	defer d.RUnlock()
+	return append([]string(nil), d.masters...)
+}
+
+// Start connects to the Zookeeper ensemble of the zk:// (or file://)
+// address and watches its masters. The returned channel is closed once
+// the masters have been read for the first time.
+func (d *ZKDetector) Start(address string) (<-chan struct{}, error) {
+	a, err := zkAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Verbose.Println("Starting master detector for ZK ", a.Hosts, a.Path)
+	conn, _, err := zk.Connect(a.Hosts, zkSessionTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to zookeeper: %v", err)
+	}
+	if a.Username != "" || a.Password != "" {
+		if err = conn.AddAuth("digest", []byte(a.Username+":"+a.Password)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to authenticate with zookeeper: %v", err)
+		}
+	}
+
+	started := make(chan struct{})
+	go d.watch(conn, a.Path, started)
+	return started, nil
+}
+
+// Stop ends watching Zookeeper; the last known masters are kept
+func (d *ZKDetector) Stop() {
+	if d == nil {
+		return
+	}
+	d.stop.Do(func() { close(d.done) })
+}
+
+// zkAddress parses a zk:// address, or reads one from a file:// address
+func zkAddress(address string) (MasterAddress, error) {
+	a, err := ParseMasterAddress(address)
+	if err != nil || a.Scheme == SchemeZk {
+		return a, err
+	}
+	if a.Scheme != SchemeFile {
+		return a, errors.New("not a zookeeper address: " + address)
+	}
+
+	as, err := readMasterFile(a.Path)
+	if err != nil {
+		return a, err
+	}
+	for _, a := range as {
+		if a.Scheme == SchemeZk {
+			return a, nil
+		}
+	}
+	return a, errors.New("no zookeeper address in " + a.Path)
+}
+
+// watch keeps the masters current until the detector is stopped
+func (d *ZKDetector) watch(conn zkConn, path string, started chan struct{}) {
+	defer conn.Close()
+	var once sync.Once
+
+	for {
+		children, _, events, err := conn.ChildrenW(path)
+		if err != nil {
+			logging.Error.Println("watching masters in zookeeper: ", err)
+			select {
+			case <-d.done:
+				return
+			case <-time.After(zkRetryInterval):
+				continue
+			}
+		}
+
+		d.update(conn, path, children)
+		once.Do(func() { close(started) })
+
+		select {
+		case <-d.done:
+			return
+		case ev := <-events:
+			logging.VeryVerbose.Println("Zookeeper event: ", ev.Type, ev.State)
+		}
+	}
+}
+
+// update reads the master info of children and makes them the current set
+func (d *ZKDetector) update(conn zkConn, path string, children []string) {
+	type node struct {
+		seq  uint64
+		addr string
+	}
+
+	var nodes []node
+	for _, child := range children {
+		seq, isJSON, ok := zkSequence(child)
+		if !ok {
+			continue
+		}
+		data, _, err := conn.Get(path + "/" + child)
+		if err != nil {
+			// the master may have gone in the meantime
+			logging.VeryVerbose.Println("Warning: reading ", child, ": ", err)
+			continue
+		}
+		addr, err := zkMasterAddr(data, isJSON)
+		if err != nil {
+			logging.Error.Println("invalid master info in ", child, ": ", err)
+			continue
+		}
+		nodes = append(nodes, node{seq, addr})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].seq < nodes[j].seq })
+
+	var masters []string
+	seen := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		if !seen[n.addr] {
+			seen[n.addr] = true
+			masters = append(masters, n.addr)
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	var leader string
+	if len(masters) > 0 {
+		leader = masters[0]
+	}
+	if leader == "" {
+		logging.Error.Println("No leader available in Zookeeper.")
+	} else if leader != d.leader {
+		logging.Verbose.Println("New master in Zookeeper ", leader)
+	}
+	if leader != d.leader {
+		select {
+		case d.changes <- struct{}{}:
+		default:
+		}
+	}
+	d.leader, d.masters = leader, masters
+}
+
+// zkSequence returns the sequence number of a master znode and whether it
+// holds JSON
+func zkSequence(name string) (uint64, bool, bool) {
+	isJSON := strings.HasPrefix(name, zkJSONInfoPrefix)
+	if isJSON {
+		name = strings.TrimPrefix(name, zkJSONInfoPrefix)
+	} else if strings.HasPrefix(name, zkInfoPrefix) {
+		name = strings.TrimPrefix(name, zkInfoPrefix)
+	} else {
+		return 0, false, false
+	}
+	seq, err := strconv.ParseUint(name, 10, 64)
+	return seq, isJSON, err == nil
+}
+
+// zkMasterInfo is the JSON form of a MasterInfo
+type zkMasterInfo struct {
+	Hostname string `json:"hostname"`
+	IP       uint32 `json:"ip"`
+	Port     uint32 `json:"port"`
+	Address  struct {
+		Hostname string `json:"hostname"`
+		IP       string `json:"ip"`
+		Port     uint32 `json:"port"`
+	} `json:"address"`
+}
+
+// zkMasterAddr returns the host:port of a master from its znode data
+func zkMasterAddr(data []byte, isJSON bool) (string, error) {
+	var info zkMasterInfo
+	if isJSON {
+		if err := json.Unmarshal(data, &info); err != nil {
+			return "", err
+		}
+	} else {
+		var pb mesos.MasterInfo
+		if err := proto.Unmarshal(data, &pb); err != nil {
+			return "", err
+		}
+		info.Hostname, info.IP, info.Port = pb.GetHostname(), pb.GetIp(), pb.GetPort()
+	}
+
+	host, port := info.Hostname, info.Port
+	if info.Address.Port != 0 {
+		port = info.Address.Port
+	}
+	switch {
+	case host != "":
+	case info.Address.Hostname != "":
+		host = info.Address.Hostname
+	case info.Address.IP != "":
+		host = info.Address.IP
+	case info.IP != 0:
+		// unpack IPv4
+		octets := make([]byte, 4, 4)
+		binary.BigEndian.PutUint32(octets, info.IP)
+		host = net.IP(octets).String()
+	default:
+		return "", errors.New("master without an address")
+	}
+	if port == 0 {
+		port = mesos.Default_MasterInfo_Port
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
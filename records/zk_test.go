@@ -0,0 +1,126 @@
+package records
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	mesos "github.com/mesos/mesos-go/mesosproto"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// fakeZK serves master znodes from memory
+type fakeZK struct {
+	sync.Mutex
+	nodes  map[string][]byte
+	events chan zk.Event
+}
+
+func (f *fakeZK) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	f.Lock()
+	defer f.Unlock()
+	var children []string
+	for name := range f.nodes {
+		children = append(children, name)
+	}
+	return children, nil, f.events, nil
+}
+
+func (f *fakeZK) Get(path string) ([]byte, *zk.Stat, error) {
+	f.Lock()
+	defer f.Unlock()
+	data, ok := f.nodes[path[len("/mesos/"):]]
+	if !ok {
+		return nil, nil, errors.New("no node")
+	}
+	return data, nil, nil
+}
+
+func (f *fakeZK) Close() {}
+
+func protoInfo(hostname string, port uint32) []byte {
+	b, _ := proto.Marshal(&mesos.MasterInfo{
+		Id: proto.String("id"), Ip: proto.Uint32(0), Port: proto.Uint32(port), Hostname: proto.String(hostname),
+	})
+	return b
+}
+
+func TestZKDetectorUpdate(t *testing.T) {
+	f := &fakeZK{nodes: map[string][]byte{
+		"info_0000000012":      protoInfo("10.0.0.2", 5050),
+		"json.info_0000000011": []byte(`{"address": {"ip": "10.0.0.1", "port": 5051}, "port": 5051}`),
+		"json.info_0000000013": []byte(`{"hostname": "master3.example.com", "port": 5050}`),
+		"log_replicas":         nil,
+		"info_0000000014":      []byte("garbage"),
+	}}
+
+	d := NewZKDetector()
+	children, _, _, _ := f.ChildrenW("/mesos")
+	d.update(f, "/mesos", append(children, "info_0000000015"))
+
+	if d.Leader() != "10.0.0.1:5051" {
+		t.Error("the lowest sequence should lead, got ", d.Leader())
+	}
+	want := []string{"10.0.0.1:5051", "10.0.0.2:5050", "master3.example.com:5050"}
+	if !reflect.DeepEqual(d.Masters(), want) {
+		t.Errorf("got masters %v, want %v", d.Masters(), want)
+	}
+}
+
+func TestZKDetectorWatch(t *testing.T) {
+	f := &fakeZK{
+		nodes:  map[string][]byte{"info_1": protoInfo("10.0.0.1", 5050)},
+		events: make(chan zk.Event),
+	}
+
+	d := NewZKDetector()
+	started := make(chan struct{})
+	go d.watch(f, "/mesos", started)
+	defer d.Stop()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("detector didn't start")
+	}
+
+	f.Lock()
+	f.nodes = map[string][]byte{"info_2": protoInfo("10.0.0.2", 5050), "info_3": protoInfo("10.0.0.3", 5050)}
+	f.Unlock()
+	f.events <- zk.Event{Type: zk.EventNodeChildrenChanged}
+	// the next event is only taken once the masters have been re-read
+	f.events <- zk.Event{Type: zk.EventNodeChildrenChanged}
+
+	if d.Leader() != "10.0.0.2:5050" || len(d.Masters()) != 2 {
+		t.Errorf("should follow masters joining and leaving, got %s %v", d.Leader(), d.Masters())
+	}
+	select {
+	case <-d.Changes():
+	default:
+		t.Error("should signal the leader change")
+	}
+}
+
+func TestZKAddress(t *testing.T) {
+	a, err := zkAddress("zk://u:p@10.0.0.1:2181,10.0.0.2:2181/mesos")
+	if err != nil || len(a.Hosts) != 2 || a.Path != "/mesos" || a.Username != "u" {
+		t.Errorf("unexpected zk address %+v, %v", a, err)
+	}
+	if _, err = zkAddress("10.0.0.1:5050"); err == nil {
+		t.Error("should reject master addresses")
+	}
+}
+
+func TestMergeMasters(t *testing.T) {
+	got := mergeMasters([]string{"10.0.0.1:5050", "10.0.0.2:5050"}, []string{"10.0.0.2:5050", "10.0.0.3:5050"})
+	want := []string{"10.0.0.1:5050", "10.0.0.2:5050", "10.0.0.3:5050"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got = mergeMasters(nil, nil); len(got) != 0 {
+		t.Error("merging nothing should give nothing")
+	}
+}
@@ -0,0 +1,165 @@
+package records
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ZoneSource yields the records of zone files in RFC 1035 master format.
+// Names are relative to the domain, and must be inside it.
+type ZoneSource struct {
+	files  []string
+	domain string
+
+	sync.Mutex
+	stats map[string]fileStat // of the files when last read
+}
+
+// fileStat tells files apart from their older versions
+type fileStat struct {
+	mtime time.Time
+	size  int64
+}
+
+// NewZoneSource returns the source of the zone files of domain
+func NewZoneSource(files []string, domain string) *ZoneSource {
+	return &ZoneSource{files: files, domain: domain}
+}
+
+// Name identifies the source
+func (s *ZoneSource) Name() string {
+	return SourceZone
+}
+
+// Records reads the records of every zone file. It fails if any file
+// can't be read or holds invalid records.
+func (s *ZoneSource) Records() ([]Record, error) {
+	s.Lock()
+	s.stats = s.stat()
+	s.Unlock()
+
+	var recs []Record
+	for _, file := range s.files {
+		rs, err := readZoneFile(file, s.domain)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rs...)
+	}
+	if err := checkCNAMEs(recs); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// Changed tells whether a file changed since the records were last read
+func (s *ZoneSource) Changed() bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.stats == nil {
+		return true
+	}
+	for file, st := range s.stat() {
+		if s.stats[file] != st {
+			return true
+		}
+	}
+	return false
+}
+
+// stat returns the modification time and size of every file; missing
+// files have a size of -1
+func (s *ZoneSource) stat() map[string]fileStat {
+	stats := make(map[string]fileStat, len(s.files))
+	for _, file := range s.files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			stats[file] = fileStat{size: -1}
+			continue
+		}
+		stats[file] = fileStat{fi.ModTime(), fi.Size()}
+	}
+	return stats
+}
+
+// readZoneFile returns the records of the zone file path for domain. SOA
+// and NS records are ignored, mesos-dns being the nameserver of the
+// domain; other types than A, SRV and CNAME are errors.
+func readZoneFile(path, domain string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	origin := dns.Fqdn(strings.ToLower(domain))
+	var recs []Record
+	var first error
+	// the parser is drained even after an error, so that it finishes
+	for t := range dns.ParseZone(f, origin, path) {
+		if first != nil {
+			continue
+		}
+		if t.Error != nil {
+			first = t.Error
+			continue
+		}
+
+		h := t.RR.Header()
+		if !dns.IsSubDomain(origin, strings.ToLower(h.Name)) {
+			first = fmt.Errorf("%s: %s is outside the domain %s", path, h.Name, origin)
+			continue
+		}
+		r, ok := NewRecord(t.RR, SourceZone)
+		switch {
+		case ok:
+			recs = append(recs, r)
+		case h.Rrtype == dns.TypeSOA || h.Rrtype == dns.TypeNS:
+		default:
+			first = fmt.Errorf("%s: unsupported %s record %s", path, dns.TypeToString[h.Rrtype], h.Name)
+		}
+	}
+	if first != nil {
+		return nil, first
+	}
+	return recs, nil
+}
+
+// NewRecord returns the record of source for rr, false unless rr is an
+// A, SRV or CNAME record
+func NewRecord(rr dns.RR, source string) (Record, bool) {
+	r := Record{Name: strings.ToLower(rr.Header().Name), Source: source}
+	switch rr := rr.(type) {
+	case *dns.A:
+		r.Type, r.Target = TypeA, rr.A.String()
+	case *dns.SRV:
+		r.Type, r.Target = TypeSRV, strings.ToLower(rr.Target)+":"+strconv.Itoa(int(rr.Port))
+	case *dns.CNAME:
+		r.Type, r.Target = TypeCNAME, strings.ToLower(rr.Target)
+	default:
+		return Record{}, false
+	}
+	return r, true
+}
+
+// checkCNAMEs checks that names with a CNAME record have no other record
+func checkCNAMEs(recs []Record) error {
+	cnames := make(map[string]int)
+	for _, r := range recs {
+		if r.Type == TypeCNAME {
+			cnames[r.Name]++
+		}
+	}
+	for _, r := range recs {
+		if n := cnames[r.Name]; n > 1 || n == 1 && r.Type != TypeCNAME {
+			return fmt.Errorf("%s has a CNAME record along with other records", r.Name)
+		}
+	}
+	return nil
+}
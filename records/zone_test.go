@@ -0,0 +1,87 @@
+package records
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestZoneSource(t *testing.T) {
+	path := writeConfig(t, `$TTL 300
+@                IN SOA  ns.mesos. root.mesos. 1 3600 600 86400 60
+@                IN NS   ns.mesos.
+registry         IN A    10.0.0.10
+NTP              IN A    10.0.0.11
+_ntp._udp        IN SRV  0 0 123 ntp
+db.mesos.        IN CNAME db.example.com.
+`)
+	defer os.Remove(path)
+
+	s := NewZoneSource([]string{path}, "mesos")
+	if !s.Changed() {
+		t.Error("a source never read should have changed")
+	}
+	recs, err := s.Records()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{
+		{"registry.mesos.", TypeA, "10.0.0.10", SourceZone},
+		{"ntp.mesos.", TypeA, "10.0.0.11", SourceZone},
+		{"_ntp._udp.mesos.", TypeSRV, "ntp.mesos.:123", SourceZone},
+		{"db.mesos.", TypeCNAME, "db.example.com.", SourceZone},
+	}
+	if !reflect.DeepEqual(recs, want) {
+		t.Errorf("want %v, got %v", want, recs)
+	}
+
+	if s.Changed() {
+		t.Error("the file didn't change")
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Changed() {
+		t.Error("the file changed")
+	}
+}
+
+func TestZoneSourceErrors(t *testing.T) {
+	for _, zone := range []string{
+		"registry IN A 10.0.0.300\n",
+		"registry.example.com. IN A 10.0.0.1\n",
+		"registry IN AAAA ::1\n",
+		"db IN CNAME db.example.com.\ndb IN A 10.0.0.1\n",
+	} {
+		path := writeConfig(t, zone)
+		if _, err := NewZoneSource([]string{path}, "mesos").Records(); err == nil {
+			t.Errorf("should reject %q", zone)
+		}
+		os.Remove(path)
+	}
+
+	if _, err := NewZoneSource([]string{"/nonexistent.zone"}, "mesos").Records(); err == nil {
+		t.Error("should fail on missing files")
+	}
+}
+
+func TestLoadConfigZoneFiles(t *testing.T) {
+	zone, err := ioutil.TempFile("", "zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone.WriteString("registry IN TXT \"x\"\n")
+	zone.Close()
+	defer os.Remove(zone.Name())
+
+	// a bad zone file keeps the last good records served instead of
+	// rejecting the configuration
+	path := writeConfig(t, `{"masters": ["10.0.0.1:5050"], "resolvers": ["8.8.8.8"], "zoneFiles": ["`+zone.Name()+`"]}`)
+	defer os.Remove(path)
+	if _, err := LoadConfig(path); err != nil {
+		t.Error("should not check the zone files, got ", err)
+	}
+}
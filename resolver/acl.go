@@ -0,0 +1,129 @@
+package resolver
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// Capabilities guarded by access control lists
+const (
+	capMesos     = "mesos"
+	capRecursion = "recursion"
+	capTransfer  = "transfer"
+	capHTTP      = "http"
+)
+
+var refused = metrics.NewCounterVec("mesos_dns_refused_total",
+	"Requests refused by access control lists, by capability.", "capability")
+
+// acl is a list of client networks; a nil acl allows every client
+type acl []*net.IPNet
+
+// acls holds the access control list of every capability
+type acls struct {
+	mesos     acl
+	recursion acl
+	transfer  acl
+	http      acl
+}
+
+// newACLs parses the access control lists of config. Entries were
+// validated along with the configuration, so invalid ones are only logged.
+func newACLs(config records.ACLConfig) acls {
+	return acls{
+		mesos:     parseACL(config.Mesos),
+		recursion: parseACL(config.Recursion),
+		transfer:  parseACL(config.Transfer),
+		http:      parseACL(config.HTTP),
+	}
+}
+
+// parseACL turns a list of CIDRs or single addresses into an acl
+func parseACL(cidrs []string) acl {
+	if cidrs == nil {
+		return nil
+	}
+
+	a := acl{}
+	for _, cidr := range cidrs {
+		ipnet, err := records.ParseCIDR(cidr)
+		if err != nil {
+			logging.Error.Println(err)
+			continue
+		}
+		a = append(a, ipnet)
+	}
+	return a
+}
+
+// allows reports whether ip belongs to one of the networks of the acl
+func (a acl) allows(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range a {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent a DNS query
+func clientIP(w dns.ResponseWriter) net.IP {
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// refuse answers r with REFUSED because the client lacks capability
+func refuse(w dns.ResponseWriter, r *dns.Msg, capability string) *dns.Msg {
+	refused.With(capability).Inc()
+	logging.VeryVerbose.Printf("refused %s query from %s\n", capability, w.RemoteAddr())
+
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeRefused)
+	if err := w.WriteMsg(m); err != nil {
+		logging.Error.Println(err)
+	}
+	return m
+}
+
+// allowHTTP wraps h so that only clients on the HTTP acl may use it
+func (a acl) allowHTTP(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !a.allows(net.ParseIP(host)) {
+			refused.With(capHTTP).Inc()
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allowHTTP wraps h so that only clients on the HTTP acl of the running
+// configuration may use it
+func (res *Resolver) allowHTTP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res.access().http.allowHTTP(h).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,140 @@
+package resolver
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// fakeWriter is a dns.ResponseWriter that keeps the message written to it
+type fakeWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *fakeWriter) LocalAddr() net.Addr         { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53} }
+func (w *fakeWriter) RemoteAddr() net.Addr        { return w.remote }
+func (w *fakeWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *fakeWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *fakeWriter) Close() error                { return nil }
+func (w *fakeWriter) TsigStatus() error           { return nil }
+func (w *fakeWriter) TsigTimersOnly(bool)         {}
+func (w *fakeWriter) Hijack()                     {}
+
+func udpClient(ip string) *fakeWriter {
+	return &fakeWriter{remote: &net.UDPAddr{IP: net.ParseIP(ip), Port: 5353}}
+}
+
+func TestACLAllows(t *testing.T) {
+	a := parseACL([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+
+	for _, ip := range []string{"10.1.2.3", "192.168.1.1", "fd00::1"} {
+		if !a.allows(net.ParseIP(ip)) {
+			t.Error("should allow ", ip)
+		}
+	}
+	for _, ip := range []string{"11.0.0.1", "192.168.1.2", "fe80::1"} {
+		if a.allows(net.ParseIP(ip)) {
+			t.Error("should not allow ", ip)
+		}
+	}
+
+	if !parseACL(nil).allows(net.ParseIP("1.2.3.4")) {
+		t.Error("a missing acl should allow everybody")
+	}
+	if parseACL([]string{}).allows(net.ParseIP("1.2.3.4")) {
+		t.Error("an empty acl should allow nobody")
+	}
+}
+
+func TestRefuseRecursion(t *testing.T) {
+	res := New(records.Config{
+		Domain: "mesos",
+		ACL:    records.ACLConfig{Recursion: []string{"10.0.0.0/8"}},
+	})
+	before := refused.With(capRecursion).Value()
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	w := udpClient("203.0.113.7")
+	res.HandleNonMesos(w, r)
+
+	if w.msg == nil || w.msg.Rcode != dns.RcodeRefused {
+		t.Error("should refuse recursion for clients outside the acl")
+	}
+	if refused.With(capRecursion).Value() != before+1 {
+		t.Error("should count refused queries")
+	}
+}
+
+func TestListenerRoles(t *testing.T) {
+	res := New(records.Config{Domain: "mesos"})
+	mesos, nonMesos := res.ListenerHandlers(records.ListenerConfig{Roles: []string{records.RoleAuthoritative}})
+
+	r := new(dns.Msg)
+	r.SetQuestion("example.com.", dns.TypeA)
+	w := udpClient("10.0.0.1")
+	nonMesos(w, r)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeRefused {
+		t.Error("an authoritative only listener should refuse recursion")
+	}
+
+	mesos, _ = res.ListenerHandlers(records.ListenerConfig{Roles: []string{records.RoleRecursion}})
+	r.SetQuestion("web.marathon.mesos.", dns.TypeA)
+	w = udpClient("10.0.0.1")
+	mesos(w, r)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeRefused {
+		t.Error("a recursion only listener should refuse queries for the domain")
+	}
+}
+
+func TestTransferACL(t *testing.T) {
+	res, err := fakeDNS(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.acls = newACLs(records.ACLConfig{Transfer: []string{"127.0.0.1"}})
+
+	r := new(dns.Msg)
+	r.SetQuestion("mesos.", dns.TypeAXFR)
+
+	w := udpClient("10.0.0.1")
+	res.HandleMesos(w, r)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeRefused {
+		t.Error("should refuse transfers for clients outside the acl")
+	}
+
+	w = udpClient("127.0.0.1")
+	res.HandleMesos(w, r)
+	if w.msg == nil || w.msg.Rcode != dns.RcodeNotImplemented {
+		t.Error("should only transfer over tcp")
+	}
+
+	if rrs := res.zoneRecords(); len(rrs) == 0 {
+		t.Error("should transfer the zone records")
+	}
+}
+
+func TestHTTPACL(t *testing.T) {
+	a := parseACL([]string{"127.0.0.1"})
+	h := a.allowHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	req.RemoteAddr = "10.0.0.1:43210"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Error("should forbid clients outside the acl")
+	}
+
+	req.RemoteAddr = "127.0.0.1:43210"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Error("should allow clients on the acl")
+	}
+}
@@ -0,0 +1,140 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// healthReport is the liveness of the process as served by /health
+type healthReport struct {
+	Healthy   bool     `json:"healthy"`
+	Listeners []string `json:"listeners"`
+	Missing   []string `json:"missing,omitempty"`
+	Stopping  bool     `json:"stopping,omitempty"`
+}
+
+// readyReport is the readiness to answer as served by /ready
+type readyReport struct {
+	Ready   bool     `json:"ready"`
+	Age     float64  `json:"age_seconds"`
+	MaxAge  float64  `json:"max_age_seconds"`
+	Leader  bool     `json:"leader"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// health returns whether every configured listener is served
+func (res *Resolver) health() healthReport {
+	served := make(map[string]bool)
+	res.life.Lock()
+	for _, s := range res.life.dns {
+		served[dnsSocket(s)] = true
+	}
+	rep := healthReport{Stopping: res.life.stopping, Listeners: []string{}}
+	res.life.Unlock()
+
+	for _, l := range res.config().DNSListeners() {
+		for _, proto := range l.Protocols {
+			kind := proto + "/" + l.Addr()
+			if served[kind] {
+				rep.Listeners = append(rep.Listeners, kind)
+			} else {
+				rep.Missing = append(rep.Missing, kind)
+			}
+		}
+	}
+	sort.Strings(rep.Listeners)
+	rep.Healthy = len(rep.Missing) == 0 && !rep.Stopping
+	return rep
+}
+
+// maxAge returns the age of the records beyond which the resolver isn't
+// ready
+func (res *Resolver) maxAge() time.Duration {
+	if s := res.config().Health.MaxAgeSeconds; s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return 3 * time.Duration(res.config().RefreshSeconds) * time.Second
+}
+
+// readiness returns whether the records at now are worth answering: they
+// were generated from the masters at least once, not longer than the
+// maximum age ago, and know the leading master
+func (res *Resolver) readiness(now time.Time) readyReport {
+	max := res.maxAge()
+	res.status.Lock()
+	generated, snapshot := !res.status.lastSuccess.IsZero(), res.status.snapshot
+	age := res.status.age(now)
+	res.status.Unlock()
+
+	rs := res.records()
+	rep := readyReport{
+		Age:    age.Seconds(),
+		MaxAge: max.Seconds(),
+		Leader: rs.As != nil && len(rs.As["leader."+res.config().Domain+"."]) > 0,
+	}
+	// records of a snapshot don't count until the masters confirm them
+	if !generated {
+		rep.Reasons = append(rep.Reasons, "no records generated yet")
+	} else if snapshot {
+		rep.Reasons = append(rep.Reasons, "records of the snapshot not confirmed by the masters yet")
+	} else if age > max {
+		rep.Reasons = append(rep.Reasons, fmt.Sprintf("records are %ds old", int64(age.Seconds())))
+	}
+	if !rep.Leader {
+		rep.Reasons = append(rep.Reasons, "no leading master known")
+	}
+	rep.Ready = len(rep.Reasons) == 0
+	return rep
+}
+
+// HandleHealth serves the liveness of the process as JSON: healthy while
+// every listener is served and the process isn't shutting down
+func (res *Resolver) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	rep := res.health()
+	w.Header().Set("Content-Type", "application/json")
+	if !rep.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(rep)
+}
+
+// HandleReady serves the readiness to answer queries as JSON
+func (res *Resolver) HandleReady(w http.ResponseWriter, r *http.Request) {
+	rep := res.readiness(time.Now())
+	if !res.health().Healthy {
+		rep.Ready = false
+		rep.Reasons = append(rep.Reasons, "not healthy")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !rep.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(rep)
+}
+
+// healthName returns the name of the self-check TXT record
+func (res *Resolver) healthName() string {
+	return "_health." + res.config().Domain + "."
+}
+
+// healthTXT returns the readiness as TXT record for name
+func (res *Resolver) healthTXT(name string) *dns.TXT {
+	rep := res.readiness(time.Now())
+	txt := []string{"ready"}
+	if !rep.Ready {
+		txt = []string{"unready"}
+		for _, reason := range rep.Reasons {
+			txt = append(txt, "reason="+reason)
+		}
+	}
+	txt = append(txt, fmt.Sprintf("age=%d", int64(rep.Age)))
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+		Txt: txt,
+	}
+}
@@ -0,0 +1,77 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+)
+
+// ListenHTTP sets up the listener of the HTTP API on the configured
+// listener and port, or on the socket inherited from a restarting process
+// or passed by the init system. The API is started with LaunchHTTP.
+func (res *Resolver) ListenHTTP() (net.Listener, error) {
+	addr := net.JoinHostPort(res.config().Listener, strconv.Itoa(res.config().HTTPPort))
+	f := res.inherit(socketHTTP)
+	if f == nil {
+		f = res.inherit("tcp/" + addr)
+	}
+	l, err := res.listenTCP(f, addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on http %s: %v", addr, err)
+	}
+	res.life.Lock()
+	res.addSocket(socketHTTP, l)
+	res.life.Unlock()
+	return l, nil
+}
+
+// LaunchHTTP serves the HTTP API on l, set up by ListenHTTP. Unless shut
+// down, the process exits once the server stops.
+func (res *Resolver) LaunchHTTP(l net.Listener) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			logging.Error.Printf("%s\n", rec)
+			os.Exit(1)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/watch", res.HandleWatch)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/v1/status", res.HandleStatus)
+	mux.HandleFunc("/v1/sources", res.HandleSources)
+	if res.config().Refresh.HTTP {
+		mux.HandleFunc("/v1/reload", res.HandleReload)
+	}
+
+	// health checks aren't rate limited, lest the checkers are throttled
+	checks := http.NewServeMux()
+	checks.Handle("/", res.limiter.limitHTTP(mux))
+	checks.HandleFunc("/health", res.HandleHealth)
+	checks.HandleFunc("/ready", res.HandleReady)
+
+	server := &http.Server{Handler: res.allowHTTP(checks)}
+	res.life.Lock()
+	res.life.http = server
+	stopping := res.life.stopping
+	res.life.Unlock()
+	var err error
+	if !stopping {
+		err = server.Serve(l)
+	}
+	if res.stopping() {
+		return
+	}
+	if err != nil {
+		logging.Error.Printf("Failed to setup http server: %s\n", err.Error())
+	} else {
+		logging.Error.Printf("Not serving http requests any more.")
+	}
+
+	os.Exit(1)
+}
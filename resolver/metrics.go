@@ -0,0 +1,102 @@
+package resolver
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+var (
+	queries = metrics.NewCounterVec("mesos_dns_queries_total",
+		"DNS queries answered, by query type, response code and zone.", "qtype", "rcode", "zone")
+	mesosLatency = metrics.NewHistogram("mesos_dns_mesos_response_seconds",
+		"Time taken to answer queries for the Mesos domain.", metrics.DefBuckets)
+	forwardLatency = metrics.NewHistogram("mesos_dns_forward_response_seconds",
+		"Time taken to answer queries forwarded to external resolvers.", metrics.DefBuckets)
+	forwardRecursions = metrics.NewCounter("mesos_dns_forward_recursions_total",
+		"Forwarded queries that were followed to the nameserver of a referral.")
+	upstreamErrors = metrics.NewCounterVec("mesos_dns_upstream_errors_total",
+		"Failed exchanges with external resolvers.", "upstream")
+	upstreamLatency = metrics.NewHistogramVec("mesos_dns_upstream_response_seconds",
+		"Time taken by exchanges with external resolvers.", metrics.DefBuckets, "upstream")
+	refreshDuration = metrics.NewHistogram("mesos_dns_refresh_duration_seconds",
+		"Time taken to regenerate records from the Mesos master state.",
+		[]float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60})
+	refreshTriggers = metrics.NewCounterVec("mesos_dns_refresh_triggers_total",
+		"Requests for an immediate refresh, by trigger.", "trigger")
+	configReloads = metrics.NewCounterVec("mesos_dns_config_reloads_total",
+		"Reloads of the configuration file, by result.", "result")
+	restartPending = metrics.NewGauge("mesos_dns_config_restart_pending",
+		"Changed settings of the configuration file that only take effect on a restart.")
+	refreshFailures = metrics.NewCounter("mesos_dns_refresh_failures_total",
+		"Refreshes that failed and kept the previous records.")
+	lastRefresh = metrics.NewGauge("mesos_dns_last_refresh_success_timestamp_seconds",
+		"Unix time of the last successful refresh.")
+	recordCount = metrics.NewGaugeVec("mesos_dns_records",
+		"Resource records currently served, by type.", "type")
+	leaderChanges = metrics.NewCounter("mesos_dns_leader_changes_total",
+		"Changes of the leading Mesos master seen between refreshes.")
+)
+
+// observeQuery counts the answer m to query r in zone and records the
+// time taken since start in latency
+func observeQuery(zone string, r, m *dns.Msg, start time.Time, latency *metrics.Histogram) {
+	latency.Observe(time.Since(start).Seconds())
+
+	qtype := "NONE"
+	if len(r.Question) > 0 {
+		qtype = typeString(r.Question[0].Qtype)
+	}
+	queries.With(qtype, rcodeString(m.Rcode), zone).Inc()
+}
+
+// observeRecords updates the record gauges for a new generation and
+// counts a leader change compared to the previous one
+func observeRecords(prev, next *records.RecordGenerator, domain string) {
+	recordCount.With("A").Set(float64(countRRs(next.As)))
+	recordCount.With("SRV").Set(float64(countRRs(next.SRVs)))
+	recordCount.With("CNAME").Set(float64(countRRs(next.CNAMEs)))
+
+	leader := "leader." + domain + "."
+	if p := prev.As[leader]; len(p) > 0 && !sameEndpoints(p, next.As[leader]) {
+		leaderChanges.Inc()
+	}
+}
+
+func countRRs(rrs map[string][]string) int {
+	n := 0
+	for _, vals := range rrs {
+		n += len(vals)
+	}
+	return n
+}
+
+func sameEndpoints(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := endpointSet(a)
+	for _, v := range b {
+		if !set[v] {
+			return false
+		}
+	}
+	return true
+}
+
+func typeString(t uint16) string {
+	if s, ok := dns.TypeToString[t]; ok {
+		return s
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func rcodeString(rcode int) string {
+	if s, ok := dns.RcodeToString[rcode]; ok {
+		return s
+	}
+	return "RCODE" + strconv.Itoa(rcode)
+}
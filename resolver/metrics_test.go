@@ -0,0 +1,43 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestObserveRecordsLeaderChange(t *testing.T) {
+	before := leaderChanges.Value()
+
+	observeRecords(watchRecords(nil, nil), watchRecords(map[string][]string{
+		"leader.mesos.": {"10.0.0.1"},
+	}, nil), "mesos")
+	if leaderChanges.Value() != before {
+		t.Error("the first leader is not a leader change")
+	}
+
+	observeRecords(watchRecords(map[string][]string{
+		"leader.mesos.": {"10.0.0.1"},
+	}, nil), watchRecords(map[string][]string{
+		"leader.mesos.": {"10.0.0.2"},
+		"web.mesos.":    {"10.0.0.3", "10.0.0.4"},
+	}, map[string][]string{
+		"_web._tcp.mesos.": {"web.mesos.:80"},
+	}), "mesos")
+	if leaderChanges.Value() != before+1 {
+		t.Error("should count a leader change")
+	}
+
+	if v := recordCount.With("A").Value(); v != 3 {
+		t.Errorf("expected 3 A records, got %v", v)
+	}
+}
+
+func TestRcodeString(t *testing.T) {
+	if s := rcodeString(dns.RcodeNameError); s != "NXDOMAIN" {
+		t.Error("wrong rcode name: ", s)
+	}
+	if s := typeString(65280); s != "TYPE65280" {
+		t.Error("wrong name for an unknown type: ", s)
+	}
+}
@@ -0,0 +1,248 @@
+package resolver
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+var rateLimited = metrics.NewCounterVec("mesos_dns_rate_limited_total",
+	"Queries, responses and HTTP requests over their rate limit, by kind and action taken.", "kind", "action")
+
+// bucketSweep is how often buckets that refilled completely are dropped
+const bucketSweep = time.Minute
+
+// tokenBucket holds the tokens left to a single client prefix
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// tokenBuckets rate limits keys to rate per second with bursts of up to
+// burst; a nil *tokenBuckets doesn't limit at all
+type tokenBuckets struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newTokenBuckets(rate float64, burst int) *tokenBuckets {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b < 1 {
+		b = rate
+		if b < 1 {
+			b = 1
+		}
+	}
+	return &tokenBuckets{
+		rate:    rate,
+		burst:   b,
+		buckets: make(map[string]*tokenBucket),
+		swept:   time.Now(),
+	}
+}
+
+// take removes a token from the bucket of key, and reports false if
+// there was none left
+func (tb *tokenBuckets) take(key string, now time.Time) bool {
+	if tb == nil {
+		return true
+	}
+
+	tb.Lock()
+	defer tb.Unlock()
+
+	if now.Sub(tb.swept) > bucketSweep {
+		tb.sweep(now)
+	}
+
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: tb.burst, last: now}
+		tb.buckets[key] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * tb.rate
+		if b.tokens > tb.burst {
+			b.tokens = tb.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep forgets the buckets that are full again, since a new bucket
+// starts out full anyway
+func (tb *tokenBuckets) sweep(now time.Time) {
+	for key, b := range tb.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*tb.rate >= tb.burst {
+			delete(tb.buckets, key)
+		}
+	}
+	tb.swept = now
+}
+
+// rateLimiter throttles queries and HTTP requests per client prefix and
+// limits identical responses (RRL)
+type rateLimiter struct {
+	queries   *tokenBuckets
+	responses *tokenBuckets
+	http      *tokenBuckets
+	slip      uint64
+	drops     uint64
+	v4        net.IPMask
+	v6        net.IPMask
+	exempt    acl
+}
+
+func newRateLimiter(c records.RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		queries:   newTokenBuckets(c.QPS, c.Burst),
+		responses: newTokenBuckets(c.ResponsesPerSecond, c.ResponsesBurst),
+		http:      newTokenBuckets(c.HTTPQPS, c.HTTPBurst),
+		slip:      uint64(c.Slip),
+		v4:        net.CIDRMask(c.IPv4PrefixLen, 8*net.IPv4len),
+		v6:        net.CIDRMask(c.IPv6PrefixLen, 8*net.IPv6len),
+		exempt:    parseACL(c.Exempt),
+	}
+}
+
+// prefix returns the client prefix ip is rate limited as; exempt clients
+// get an empty prefix
+func (rl *rateLimiter) prefix(ip net.IP) string {
+	if ip == nil || (rl.exempt != nil && rl.exempt.allows(ip)) {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(rl.v4).String()
+	}
+	return ip.Mask(rl.v6).String()
+}
+
+// slipped reports whether a dropped UDP response should be sent truncated
+// instead, so that legitimate clients retry over TCP
+func (rl *rateLimiter) slipped() bool {
+	return rl.slip > 0 && atomic.AddUint64(&rl.drops, 1)%rl.slip == 0
+}
+
+// limit throttles the query r. It returns false if the query was dropped
+// (or answered with a truncated or refused response) and must not be
+// handled; otherwise it returns the writer to answer through, which
+// applies response rate limiting.
+func (rl *rateLimiter) limit(w dns.ResponseWriter, r *dns.Msg) (dns.ResponseWriter, bool) {
+	if rl.queries == nil && rl.responses == nil {
+		return w, true
+	}
+	prefix := rl.prefix(clientIP(w))
+	if prefix == "" {
+		return w, true
+	}
+	_, tcp := w.RemoteAddr().(*net.TCPAddr)
+
+	if !rl.queries.take(prefix, time.Now()) {
+		m := new(dns.Msg)
+		switch {
+		case tcp:
+			m.SetRcode(r, dns.RcodeRefused)
+			rateLimited.With("query", "refuse").Inc()
+		case rl.slipped():
+			m.SetReply(r)
+			m.Truncated = true
+			rateLimited.With("query", "slip").Inc()
+		default:
+			rateLimited.With("query", "drop").Inc()
+			return nil, false
+		}
+		if err := w.WriteMsg(m); err != nil {
+			logging.Error.Println(err)
+		}
+		return nil, false
+	}
+
+	if rl.responses == nil || tcp {
+		return w, true
+	}
+	return &rrlWriter{ResponseWriter: w, rl: rl, prefix: prefix, query: r}, true
+}
+
+// rrlWriter drops UDP responses that are identical for a client prefix
+// beyond the configured rate
+type rrlWriter struct {
+	dns.ResponseWriter
+	rl     *rateLimiter
+	prefix string
+	query  *dns.Msg
+}
+
+// WriteMsg writes m unless the client prefix exceeded its rate of
+// identical responses
+func (w *rrlWriter) WriteMsg(m *dns.Msg) error {
+	if w.rl.responses.take(responseKey(w.prefix, m), time.Now()) {
+		return w.ResponseWriter.WriteMsg(m)
+	}
+
+	if !w.rl.slipped() {
+		rateLimited.With("response", "drop").Inc()
+		return nil
+	}
+	rateLimited.With("response", "slip").Inc()
+	tc := new(dns.Msg)
+	tc.SetReply(w.query)
+	tc.Truncated = true
+	return w.ResponseWriter.WriteMsg(tc)
+}
+
+// responseKey identifies identical responses to a client prefix. Negative
+// answers are keyed by their zone rather than the name, so that queries
+// for random names can't evade the limit.
+func responseKey(prefix string, m *dns.Msg) string {
+	name, qtype := "", uint16(0)
+	if len(m.Question) > 0 {
+		name, qtype = strings.ToLower(m.Question[0].Name), m.Question[0].Qtype
+	}
+	if m.Rcode == dns.RcodeNameError || (m.Rcode == dns.RcodeSuccess && len(m.Answer) == 0) {
+		qtype = 0
+		if len(m.Ns) > 0 {
+			name = strings.ToLower(m.Ns[0].Header().Name)
+		}
+	}
+	return prefix + "/" + name + "/" + strconv.Itoa(int(qtype)) + "/" + strconv.Itoa(m.Rcode)
+}
+
+// limitHTTP wraps h so that every client prefix is limited to its rate
+// of HTTP requests
+func (rl *rateLimiter) limitHTTP(h http.Handler) http.Handler {
+	if rl.http == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if prefix := rl.prefix(net.ParseIP(host)); prefix != "" && !rl.http.take(prefix, time.Now()) {
+			rateLimited.With("http", "refuse").Inc()
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,123 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+func TestTokenBuckets(t *testing.T) {
+	tb := newTokenBuckets(10, 2)
+	now := time.Now()
+
+	if !tb.take("a", now) || !tb.take("a", now) {
+		t.Error("should allow a burst of 2")
+	}
+	if tb.take("a", now) {
+		t.Error("should limit after the burst")
+	}
+	if !tb.take("b", now) {
+		t.Error("should limit each key separately")
+	}
+	if !tb.take("a", now.Add(100*time.Millisecond)) {
+		t.Error("should refill at the configured rate")
+	}
+
+	tb.sweep(now.Add(time.Hour))
+	if len(tb.buckets) != 0 {
+		t.Error("should forget full buckets")
+	}
+
+	if !(*tokenBuckets)(nil).take("a", now) {
+		t.Error("a nil limiter should allow everything")
+	}
+}
+
+func TestRateLimiterPrefix(t *testing.T) {
+	rl := newRateLimiter(records.RateLimitConfig{
+		IPv4PrefixLen: 24,
+		IPv6PrefixLen: 56,
+		Exempt:        []string{"10.0.0.0/8"},
+	})
+
+	if rl.prefix(net.ParseIP("192.168.1.7")) != rl.prefix(net.ParseIP("192.168.1.200")) {
+		t.Error("should group clients of the same /24")
+	}
+	if rl.prefix(net.ParseIP("2001:db8:0:1::1")) != "2001:db8::" {
+		t.Error("should group clients of the same /56")
+	}
+	if rl.prefix(net.ParseIP("10.1.2.3")) != "" {
+		t.Error("should exempt clients on the exempt list")
+	}
+}
+
+func TestRateLimiterQueries(t *testing.T) {
+	rl := newRateLimiter(records.RateLimitConfig{
+		QPS:           1,
+		Burst:         1,
+		Slip:          2,
+		IPv4PrefixLen: 24,
+		IPv6PrefixLen: 56,
+	})
+
+	r := new(dns.Msg)
+	r.SetQuestion("web.marathon.mesos.", dns.TypeA)
+
+	if _, ok := rl.limit(udpClient("192.168.1.1"), r); !ok {
+		t.Error("should allow the first query")
+	}
+
+	var truncated, dropped int
+	for i := 0; i < 4; i++ {
+		w := udpClient("192.168.1.2")
+		if _, ok := rl.limit(w, r); ok {
+			t.Fatal("should limit the prefix after its burst")
+		}
+		if w.msg == nil {
+			dropped++
+		} else if w.msg.Truncated {
+			truncated++
+		}
+	}
+	if truncated != 2 || dropped != 2 {
+		t.Errorf("expected every second query to slip, got %d truncated and %d dropped", truncated, dropped)
+	}
+}
+
+func TestResponseRateLimit(t *testing.T) {
+	rl := newRateLimiter(records.RateLimitConfig{
+		ResponsesPerSecond: 1,
+		ResponsesBurst:     1,
+		IPv4PrefixLen:      24,
+		IPv6PrefixLen:      56,
+	})
+
+	r := new(dns.Msg)
+	r.SetQuestion("web.marathon.mesos.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	w := udpClient("192.168.1.1")
+	rw, _ := rl.limit(w, r)
+	rw.WriteMsg(m)
+	if w.msg == nil {
+		t.Fatal("should send the first response")
+	}
+
+	w = udpClient("192.168.1.1")
+	rw, _ = rl.limit(w, r)
+	rw.WriteMsg(m)
+	if w.msg != nil {
+		t.Error("should drop identical responses over the limit")
+	}
+
+	w = &fakeWriter{remote: &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}}
+	rw, _ = rl.limit(w, r)
+	rw.WriteMsg(m)
+	if w.msg == nil {
+		t.Error("should never limit responses over tcp")
+	}
+}
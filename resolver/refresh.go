@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+)
+
+// triggers of immediate refreshes
+const (
+	triggerLeader = "leader"
+	triggerHTTP   = "http"
+	triggerSignal = "signal"
+	triggerConfig = "config"
+)
+
+// trigger asks for an immediate refresh. Triggers arriving while one is
+// pending are coalesced into a single refresh.
+func (res *Resolver) trigger(trigger string) {
+	refreshTriggers.With(trigger).Inc()
+	select {
+	case res.triggers <- trigger:
+	default:
+	}
+}
+
+// Refresh reloads the records every RefreshSeconds and whenever triggered
+// by a new leader, POST /v1/reload, SIGUSR1 or new masters in the reloaded
+// configuration, never running two reloads closer than the configured
+// minimum interval. It never returns; reloads only ever run from here once
+// it is started.
+func (res *Resolver) Refresh() {
+	if res.config().Refresh.Signal {
+		usr1 := make(chan os.Signal, 1)
+		signal.Notify(usr1, syscall.SIGUSR1)
+		go func() {
+			for _ = range usr1 {
+				res.trigger(triggerSignal)
+			}
+		}()
+	}
+	res.refreshLoop(res.Reload, nil)
+}
+
+// refreshLoop runs reload as Refresh describes until stop is closed
+func (res *Resolver) refreshLoop(reload func(), stop <-chan struct{}) {
+	rc := res.config().Refresh
+	debounce := time.Duration(rc.DebounceMillis) * time.Millisecond
+	minInterval := time.Duration(rc.MinIntervalSeconds) * time.Second
+
+	interval := func() time.Duration {
+		return time.Duration(res.config().RefreshSeconds) * time.Second
+	}
+	ticker := time.NewTicker(interval())
+	defer func() { ticker.Stop() }()
+
+	// the changes that led to the first reload are in already
+	changes := res.ZK.Changes()
+	if !rc.LeaderChange {
+		changes = nil
+	}
+	select {
+	case <-changes:
+	default:
+	}
+
+	last := time.Now()
+	var pending <-chan time.Time
+	run := func() {
+		pending = nil
+		reload()
+		last = time.Now()
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if pending == nil {
+				run()
+			}
+		case <-res.reconfigured:
+			ticker.Stop()
+			ticker = time.NewTicker(interval())
+		case <-changes:
+			res.trigger(triggerLeader)
+		case trigger := <-res.triggers:
+			if pending != nil {
+				continue
+			}
+			delay := debounce
+			if wait := minInterval - time.Since(last); wait > delay {
+				delay = wait
+			}
+			logging.VeryVerbose.Println("refresh triggered by ", trigger, ", reloading in ", delay)
+			pending = time.After(delay)
+		case <-pending:
+			run()
+		}
+	}
+}
+
+// HandleReload triggers a refresh on POST requests
+func (res *Resolver) HandleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res.trigger(triggerHTTP)
+	w.WriteHeader(http.StatusAccepted)
+}
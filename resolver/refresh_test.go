@@ -0,0 +1,80 @@
+package resolver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+func refreshResolver(debounceMillis, minIntervalSeconds int) (*Resolver, chan struct{}, chan struct{}) {
+	res := New(records.Config{
+		RefreshSeconds: 3600,
+		Refresh:        records.RefreshConfig{DebounceMillis: debounceMillis, MinIntervalSeconds: minIntervalSeconds},
+	})
+	reloads := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	go res.refreshLoop(func() { reloads <- struct{}{} }, stop)
+	return res, reloads, stop
+}
+
+func TestRefreshDebounce(t *testing.T) {
+	res, reloads, stop := refreshResolver(20, 0)
+	defer close(stop)
+
+	for i := 0; i < 5; i++ {
+		res.trigger(triggerHTTP)
+	}
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatal("a trigger should reload")
+	}
+	select {
+	case <-reloads:
+		t.Error("triggers in a burst should be coalesced into one reload")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRefreshMinInterval(t *testing.T) {
+	res, reloads, stop := refreshResolver(0, 1)
+	defer close(stop)
+
+	start := time.Now()
+	res.trigger(triggerSignal)
+	select {
+	case <-reloads:
+		if time.Since(start) < 900*time.Millisecond {
+			t.Error("reloaded before the minimum interval passed")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("a trigger should reload after the minimum interval")
+	}
+}
+
+func TestHandleReload(t *testing.T) {
+	res := New(records.Config{})
+
+	w := httptest.NewRecorder()
+	res.HandleReload(w, &http.Request{Method: "GET"})
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("should only accept POST, got ", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	res.HandleReload(w, &http.Request{Method: "POST"})
+	if w.Code != http.StatusAccepted {
+		t.Error("should accept POST, got ", w.Code)
+	}
+	select {
+	case trigger := <-res.triggers:
+		if trigger != triggerHTTP {
+			t.Error("wrong trigger: ", trigger)
+		}
+	default:
+		t.Error("POST should trigger a refresh")
+	}
+}
@@ -0,0 +1,124 @@
+package resolver
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// config returns the running configuration, which must not be modified;
+// a zero Resolver runs with the zero configuration
+func (res *Resolver) config() *records.Config {
+	res.configLock.RLock()
+	defer res.configLock.RUnlock()
+	if res.cfg == nil {
+		return &records.Config{}
+	}
+	return res.cfg
+}
+
+// access returns the acls of the running configuration
+func (res *Resolver) access() acls {
+	res.configLock.RLock()
+	defer res.configLock.RUnlock()
+	return res.acls
+}
+
+// restartPending returns the settings changed in the configuration file
+// that only take effect on a restart
+func (res *Resolver) restartPending() []string {
+	res.configLock.RLock()
+	defer res.configLock.RUnlock()
+	return res.pending
+}
+
+// ReloadConfig loads the configuration again and applies the
+// settings that take effect without a restart. An invalid file is
+// rejected, leaving the running configuration untouched.
+func (res *Resolver) ReloadConfig() error {
+	cur := res.config()
+	next, err := cur.Reread()
+	if err != nil {
+		configReloads.With("error").Inc()
+		logging.Error.Println("rejected configuration: ", err)
+		return err
+	}
+
+	c, restart := cur.Reload(next)
+	for _, name := range restart {
+		logging.Error.Printf("configuration: %s changed, restart to apply it\n", name)
+	}
+
+	res.configLock.Lock()
+	res.cfg = &c
+	res.acls = newACLs(c.ACL)
+	res.pending = restart
+	res.configLock.Unlock()
+	configReloads.With("ok").Inc()
+	restartPending.Set(float64(len(restart)))
+	logging.Verbose.Println("configuration reloaded from ", c.File)
+
+	select {
+	case res.reconfigured <- struct{}{}:
+	default:
+	}
+	if !reflect.DeepEqual(cur.Masters, c.Masters) {
+		res.trigger(triggerConfig)
+	}
+	return nil
+}
+
+// WatchConfig reloads the configuration on SIGHUP and whenever the
+// configuration file changes. It never returns.
+func (res *Resolver) WatchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	poll := time.Duration(res.config().ConfigPollSeconds) * time.Second
+	res.configLoop(hup, poll, nil)
+}
+
+// configLoop runs what WatchConfig describes, checking the file every
+// poll unless zero, until stop is closed
+func (res *Resolver) configLoop(hup <-chan os.Signal, poll time.Duration, stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if poll > 0 {
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// stat returns the modification time and size of the file
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(res.config().File)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	mtime, size := stat()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			logging.Verbose.Println("SIGHUP: reloading configuration")
+			_ = res.ReloadConfig()
+			mtime, size = stat()
+			res.refreshChanged()
+		case <-tick:
+			m, s := stat()
+			if s < 0 || (m.Equal(mtime) && s == size) {
+				continue
+			}
+			mtime, size = m, s
+			logging.Verbose.Println("configuration file changed, reloading")
+			_ = res.ReloadConfig()
+		}
+	}
+}
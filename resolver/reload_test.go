@@ -0,0 +1,85 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// configResolver returns a resolver for the configuration json, along
+// with the file it is loaded from
+func configResolver(t *testing.T, json string) (*Resolver, string) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(json)
+	f.Close()
+
+	config, err := records.LoadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(config), f.Name()
+}
+
+func TestReloadConfig(t *testing.T) {
+	res, path := configResolver(t, `{"masters": ["10.0.0.1:5050"], "resolvers": ["8.8.8.8"]}`)
+	defer os.Remove(path)
+
+	ioutil.WriteFile(path, []byte(`{"masters": ["10.0.0.1:5050"], "resolvers": ["8.8.4.4"],
+		"ttl": 30, "port": 5353, "acl": {"mesos": ["10.0.0.0/8"]}}`), 0644)
+	if err := res.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	c := res.config()
+	if c.TTL != 30 || c.Resolvers[0] != "8.8.4.4" || c.Port != 53 {
+		t.Errorf("should apply reloadable settings only, got %+v", c)
+	}
+	if res.access().mesos == nil {
+		t.Error("should apply the new acls")
+	}
+
+	w := httptest.NewRecorder()
+	res.HandleStatus(w, &http.Request{Method: "GET"})
+	if !strings.Contains(w.Body.String(), `"restart_pending":["Port"]`) {
+		t.Error("should report the port as waiting for a restart, got ", w.Body.String())
+	}
+
+	ioutil.WriteFile(path, []byte(`{"masters": ["10.0.0.1:5050"], "ttl": "never"}`), 0644)
+	if err := res.ReloadConfig(); err == nil {
+		t.Error("should reject an invalid configuration")
+	}
+	if res.config().TTL != 30 {
+		t.Error("a rejected configuration should leave the running one untouched")
+	}
+}
+
+func TestConfigLoopPolls(t *testing.T) {
+	res, path := configResolver(t, `{"masters": ["10.0.0.1:5050"], "resolvers": ["8.8.8.8"]}`)
+	defer os.Remove(path)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go res.configLoop(nil, 10*time.Millisecond, stop)
+	time.Sleep(20 * time.Millisecond)
+
+	ioutil.WriteFile(path, []byte(`{"masters": ["10.0.0.1:5050"], "resolvers": ["8.8.8.8"], "ttl": 120}`), 0644)
+	for i := 0; i < 100 && res.config().TTL != 120; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if res.config().TTL != 120 {
+		t.Error("a changed file should be reloaded")
+	}
+	select {
+	case <-res.reconfigured:
+	default:
+		t.Error("a reload should reconfigure the refresh loop")
+	}
+}
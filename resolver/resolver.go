@@ -4,13 +4,16 @@ package resolver
 
 import (
 	"errors"
+	"fmt"
 	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
 	"github.com/mesosphere/mesos-dns/records"
 	"math/rand"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
@@ -30,16 +33,19 @@ func (res *Resolver) resolveOut(r *dns.Msg, nameserver string, proto string, cnt
 	c.Net = proto
 
 	var t time.Duration = 5 * 1e9
-	if res.Config.Timeout != 0 {
-		t = time.Duration(int64(res.Config.Timeout * 1e9))
+	if res.config().Timeout != 0 {
+		t = time.Duration(int64(res.config().Timeout * 1e9))
 	}
 
 	c.DialTimeout = t
 	c.ReadTimeout = t
 	c.WriteTimeout = t
 
+	start := time.Now()
 	in, _, err = c.Exchange(r, nameserver)
+	upstreamLatency.With(nameserver).Observe(time.Since(start).Seconds())
 	if err != nil {
+		upstreamErrors.With(nameserver).Inc()
 		return in, err
 	}
 
@@ -47,7 +53,7 @@ func (res *Resolver) resolveOut(r *dns.Msg, nameserver string, proto string, cnt
 	if (in != nil) && (len(in.Answer) == 0) && (!in.MsgHdr.Authoritative) && (len(in.Ns) > 0) && (err != nil) {
 
 		if cnt == recurseCnt {
-			logging.CurLog.NonMesosRecursed.Inc()
+			forwardRecursions.Inc()
 		}
 
 		if cnt > 0 {
@@ -88,7 +94,7 @@ func (res *Resolver) splitDomain(dom string) (host string, port int) {
 
 // formatSRV returns the SRV resource record for target
 func (res *Resolver) formatSRV(name string, target string) (*dns.SRV, error) {
-	ttl := uint32(res.Config.TTL)
+	ttl := uint32(res.config().TTL)
 
 	h, p := res.splitDomain(target)
 
@@ -108,7 +114,7 @@ func (res *Resolver) formatSRV(name string, target string) (*dns.SRV, error) {
 
 // formatA returns the A resource record for target
 func (res *Resolver) formatA(dom string, target string) (*dns.A, error) {
-	ttl := uint32(res.Config.TTL)
+	ttl := uint32(res.config().TTL)
 
 	h, _ := res.splitDomain(target)
 
@@ -130,9 +136,22 @@ func (res *Resolver) formatA(dom string, target string) (*dns.A, error) {
 	}
 }
 
+// formatCNAME returns the CNAME resource record for target
+func (res *Resolver) formatCNAME(dom string, target string) *dns.CNAME {
+	return &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   dom,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(res.config().TTL),
+		},
+		Target: target,
+	}
+}
+
 // formatSOA returns the SOA resource record for the mesos domain
 func (res *Resolver) formatSOA(dom string) (*dns.SOA, error) {
-	ttl := uint32(res.Config.TTL)
+	ttl := uint32(res.config().TTL)
 
 	return &dns.SOA{
 		Hdr: dns.RR_Header{
@@ -141,8 +160,8 @@ func (res *Resolver) formatSOA(dom string) (*dns.SOA, error) {
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ns:      res.Config.Mname,
-		Mbox:    res.Config.Email,
+		Ns:      res.config().Mname,
+		Mbox:    res.config().Email,
 		Serial:  uint32(time.Now().Unix()),
 		Refresh: ttl,
 		Retry:   600,
@@ -166,16 +185,33 @@ func shuffleAnswers(answers []dns.RR) []dns.RR {
 
 // HandleNonMesos makes non-mesos queries
 func (res *Resolver) HandleNonMesos(w dns.ResponseWriter, r *dns.Msg) {
+	defer res.begin()()
+
 	var err error
 	var m *dns.Msg
 
+	start := time.Now()
+
+	var ok bool
+	if w, ok = res.limiter.limit(w, r); !ok {
+		return
+	}
+
+	if !res.access().recursion.allows(clientIP(w)) {
+		m = refuse(w, r, capRecursion)
+		observeQuery(".", r, m, start, forwardLatency)
+		res.logQuery(w, r, m, start, logging.SourceLocal)
+		return
+	}
+
 	proto := "udp"
 	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
 		proto = "tcp"
 	}
 
-	for i := 0; i < len(res.Config.Resolvers); i++ {
-		nameserver := res.Config.Resolvers[i] + ":53"
+	resolvers := res.forwardTo(res.selectView(w, r))
+	for i := 0; i < len(resolvers); i++ {
+		nameserver := resolvers[i] + ":53"
 		m, err = res.resolveOut(r, nameserver, proto, recurseCnt)
 		if err == nil {
 			break
@@ -195,22 +231,14 @@ func (res *Resolver) HandleNonMesos(w dns.ResponseWriter, r *dns.Msg) {
 		err = errors.New("nil msg")
 	}
 
-	// tracing info
-	logging.CurLog.NonMesosRequests.Inc()
-
 	if err != nil {
 		logging.Error.Println(err)
-		logging.CurLog.NonMesosFailed.Inc()
-	} else {
-
-		// nxdomain
-		if len(m.Answer) == 0 {
-			logging.CurLog.NonMesosNXDomain.Inc()
-		} else {
-			logging.CurLog.NonMesosSuccess.Inc()
-		}
 	}
 
+	// tracing info
+	observeQuery(".", r, m, start, forwardLatency)
+	res.logQuery(w, r, m, start, logging.SourceUpstream)
+
 	err = w.WriteMsg(m)
 	if err != nil {
 		logging.Error.Println(err)
@@ -221,28 +249,98 @@ func (res *Resolver) HandleNonMesos(w dns.ResponseWriter, r *dns.Msg) {
 // question with resource answer(s)
 // it can handle {A, SRV, ANY}
 func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
+	defer res.begin()()
+
 	var err error
 
+	start := time.Now()
+
+	var ok bool
+	if w, ok = res.limiter.limit(w, r); !ok {
+		return
+	}
+
 	dom := strings.ToLower(cleanWild(r.Question[0].Name))
 	qType := r.Question[0].Qtype
 
+	if qType == dns.TypeAXFR || qType == dns.TypeIXFR {
+		res.handleTransfer(w, r, start)
+		return
+	}
+
+	if !res.access().mesos.allows(clientIP(w)) {
+		m := refuse(w, r, capMesos)
+		observeQuery(res.config().Domain+".", r, m, start, mesosLatency)
+		res.logQuery(w, r, m, start, logging.SourceLocal)
+		return
+	}
+
+	if r.Opcode == dns.OpcodeUpdate {
+		res.handleUpdate(w, r, start)
+		return
+	}
+
+	// the self-check records are answered even while stale
+	var self func(string) *dns.TXT
+	switch {
+	case qType != dns.TypeTXT:
+	case dom == res.statusName() && res.config().Stale.StatusRecord:
+		self = res.statusTXT
+	case dom == res.healthName() && res.config().Health.Record:
+		self = res.healthTXT
+	}
+	if self != nil {
+		m := new(dns.Msg)
+		m.Authoritative = true
+		m.SetReply(r)
+		m.Answer = append(m.Answer, self(r.Question[0].Name))
+		observeQuery(res.config().Domain+".", r, m, start, mesosLatency)
+		res.logQuery(w, r, m, start, logging.SourceLocal)
+		if err = w.WriteMsg(m); err != nil {
+			logging.Error.Println(err)
+		}
+		return
+	}
+
+	rs, as := res.viewRecords(res.selectView(w, r))
+
 	m := new(dns.Msg)
 	m.Authoritative = true
 	m.RecursionAvailable = true
 	m.SetReply(r)
 
+	// a CNAME stands for every type of its name; targets in the domain are
+	// followed once for A queries
+	cnamed := false
+	if cnames := rs.CNAMEs[dom]; len(cnames) > 0 && qType != dns.TypeCNAME {
+		var targets []dns.RR
+		if qType == dns.TypeA || qType == dns.TypeANY {
+			for _, host := range as[cnames[0]] {
+				if rr, err := res.formatA(cnames[0], host); err == nil {
+					targets = append(targets, rr)
+				}
+			}
+		}
+		m.Answer = append([]dns.RR{res.formatCNAME(r.Question[0].Name, cnames[0])}, shuffleAnswers(targets)...)
+		cnamed, qType = true, dns.TypeNone
+	}
+
 	switch qType {
+	case dns.TypeCNAME:
+		for _, target := range rs.CNAMEs[dom] {
+			m.Answer = append(m.Answer, res.formatCNAME(r.Question[0].Name, target))
+		}
 	case dns.TypeSRV:
-		for i := 0; i < len(res.rs.SRVs[dom]); i++ {
-			rr, err := res.formatSRV(r.Question[0].Name, res.rs.SRVs[dom][i])
+		for i := 0; i < len(rs.SRVs[dom]); i++ {
+			rr, err := res.formatSRV(r.Question[0].Name, rs.SRVs[dom][i])
 			if err != nil {
 				logging.Error.Println(err)
 			} else {
 				m.Answer = append(m.Answer, rr)
 				// return one corresponding A record add additional info
-				host := strings.Split(res.rs.SRVs[dom][i],":")[0]
-				if len(res.rs.As[host]) != 0 {
-					rr, err := res.formatA(host, res.rs.As[host][0])
+				host := strings.Split(rs.SRVs[dom][i], ":")[0]
+				if len(as[host]) != 0 {
+					rr, err := res.formatA(host, as[host][0])
 					if err != nil {
 						logging.Error.Println(err)
 					} else {
@@ -252,8 +350,8 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 			}
 		}
 	case dns.TypeA:
-		for i := 0; i < len(res.rs.As[dom]); i++ {
-			rr, err := res.formatA(dom, res.rs.As[dom][i])
+		for i := 0; i < len(as[dom]); i++ {
+			rr, err := res.formatA(dom, as[dom][i])
 			if err != nil {
 				logging.Error.Println(err)
 			} else {
@@ -262,8 +360,8 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 		}
 	case dns.TypeANY:
 		// refactor me
-		for i := 0; i < len(res.rs.As[dom]); i++ {
-			rr, err := res.formatA(r.Question[0].Name, res.rs.As[dom][i])
+		for i := 0; i < len(as[dom]); i++ {
+			rr, err := res.formatA(r.Question[0].Name, as[dom][i])
 			if err != nil {
 				logging.Error.Println(err)
 			} else {
@@ -271,16 +369,16 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 			}
 		}
 
-		for i := 0; i < len(res.rs.SRVs[dom]); i++ {
-			rr, err := res.formatSRV(dom, res.rs.SRVs[dom][i])
+		for i := 0; i < len(rs.SRVs[dom]); i++ {
+			rr, err := res.formatSRV(dom, rs.SRVs[dom][i])
 			if err != nil {
 				logging.Error.Println(err)
 			} else {
 				m.Answer = append(m.Answer, rr)
 				// return one corresponding A record add additional info
-				host := strings.Split(res.rs.SRVs[dom][i],":")[0]
-				if len(res.rs.As[host]) != 0 {
-					rr, err := res.formatA(host, res.rs.As[host][0])
+				host := strings.Split(rs.SRVs[dom][i], ":")[0]
+				if len(as[host]) != 0 {
+					rr, err := res.formatA(host, as[host][0])
 					if err != nil {
 						logging.Error.Println(err)
 					} else {
@@ -304,15 +402,14 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 
 	}
 
-	// shuffle answers
-	m.Answer = shuffleAnswers(m.Answer)
-
-	// tracing info
-	logging.CurLog.MesosRequests.Inc()
+	// shuffle answers, which follow the CNAME if any
+	if !cnamed {
+		m.Answer = shuffleAnswers(m.Answer)
+	}
 
 	if err != nil {
-		logging.CurLog.MesosFailed.Inc()
-	} else if (qType == dns.TypeAAAA) && (len(res.rs.SRVs[dom]) > 0 || len(res.rs.As[dom]) > 0) {
+		m.SetRcode(r, dns.RcodeServerFailure)
+	} else if (qType == dns.TypeAAAA) && (len(rs.SRVs[dom]) > 0 || len(as[dom]) > 0) {
 
 		m = new(dns.Msg)
 		m.Authoritative = true
@@ -338,22 +435,42 @@ func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 				m.Ns = append(m.Ns, rr)
 			}
 
-			logging.CurLog.MesosNXDomain.Inc()
-			logging.VeryVerbose.Println("total A rrs:\t" + strconv.Itoa(len(res.rs.As)))
+			logging.VeryVerbose.Println("total A rrs:\t" + strconv.Itoa(len(as)))
 			logging.VeryVerbose.Println("failed looking for " + r.Question[0].String())
-		} else {
-			logging.CurLog.MesosSuccess.Inc()
 		}
 	}
 
+	m = res.staleAnswer(r, m)
+
+	// tracing info
+	observeQuery(res.config().Domain+".", r, m, start, mesosLatency)
+	res.logQuery(w, r, m, start, logging.SourceMesos)
+
 	err = w.WriteMsg(m)
 	if err != nil {
 		logging.Error.Println(err)
 	}
 }
 
-// Serve starts a dns server for net protocol
-func (res *Resolver) Serve(net string) {
+// Listen sets up a dns server for net protocol on addr, handling queries
+// with h, on the socket inherited from a restarting process or passed by
+// the init system if there is one. The server is started with Serve.
+func (res *Resolver) Listen(net, addr string, h dns.Handler) (*dns.Server, error) {
+	server := &dns.Server{
+		Addr:       addr,
+		Net:        net,
+		Handler:    h,
+		TsigSecret: res.config().Updates.Secrets(),
+	}
+	if err := res.listen(server); err != nil {
+		return nil, fmt.Errorf("cannot listen on %s %s: %v", net, addr, err)
+	}
+	return server, nil
+}
+
+// Serve serves queries with a server set up by Listen. Unless shut down,
+// the process exits once the server stops.
+func (res *Resolver) Serve(server *dns.Server) {
 	defer func() {
 		if rec := recover(); rec != nil {
 			logging.Error.Printf("%s\n", rec)
@@ -361,15 +478,15 @@ func (res *Resolver) Serve(net string) {
 		}
 	}()
 
-	server := &dns.Server{
-		Addr:       res.Config.Listener + ":" + strconv.Itoa(res.Config.Port),
-		Net:        net,
-		TsigSecret: nil,
+	if !res.activate(server) {
+		return
+	}
+	err := server.ActivateAndServe()
+	if res.stopping() {
+		return
 	}
-
-	err := server.ListenAndServe()
 	if err != nil {
-		logging.Error.Printf("Failed to setup "+net+" server: %s\n", err.Error())
+		logging.Error.Printf("Failed to setup "+server.Net+" server on "+server.Addr+": %s\n", err.Error())
 	} else {
 		logging.Error.Printf("Not listening/serving any more requests.")
 	}
@@ -377,21 +494,202 @@ func (res *Resolver) Serve(net string) {
 	os.Exit(1)
 }
 
+// ListenerHandlers returns the handlers of queries for the domain and of
+// the others on listener l. Queries of the roles l lacks are refused.
+func (res *Resolver) ListenerHandlers(l records.ListenerConfig) (mesos, nonMesos dns.HandlerFunc) {
+	mesos, nonMesos = res.HandleMesos, res.HandleNonMesos
+	if !l.HasRole(records.RoleAuthoritative) {
+		mesos = res.refuseRole(capMesos, res.config().Domain+".", mesosLatency)
+	}
+	if !l.HasRole(records.RoleRecursion) {
+		nonMesos = res.refuseRole(capRecursion, ".", forwardLatency)
+	}
+	return mesos, nonMesos
+}
+
+// refuseRole returns a handler refusing every query, for listeners
+// without the role of capability
+func (res *Resolver) refuseRole(capability, zone string, latency *metrics.Histogram) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		defer res.begin()()
+		start := time.Now()
+		m := refuse(w, r, capability)
+		observeQuery(zone, r, m, start, latency)
+		res.logQuery(w, r, m, start, logging.SourceLocal)
+	}
+}
+
 // Resolver holds configuration information and the resource records
 // refactor me
 type Resolver struct {
-	rs     records.RecordGenerator
-	Config records.Config
+	rs      *records.RecordGenerator
+	rsLock  sync.RWMutex
+	watch   *watchHub
+	limiter *rateLimiter
+
+	// the configuration and the acls parsed from it are swapped together
+	// when the configuration is reloaded
+	cfg          *records.Config
+	acls         acls
+	pending      []string
+	configLock   sync.RWMutex
+	reconfigured chan struct{}
+
+	views         []*view
+	projections   map[string]map[string][]string
+	ecsForwarders acl
+	triggers      chan string
+	status        refreshStatus
+	life          lifecycle
+	inflight      int64
+
+	// the records of every source are merged into rs, one merge at a time
+	// so that the last one started is installed last
+	srcs      sources
+	srcsLock  sync.Mutex
+	mergeLock sync.Mutex
+
+	// updates are applied one at a time (RFC 2136 section 3.7)
+	updateLock sync.Mutex
+
+	// QueryLog is the optional log of answered queries, nil when disabled
+	QueryLog *logging.QueryLog
+
+	// Masters is the client used to load state from the Mesos masters;
+	// refreshes fail without it
+	Masters *records.MasterClient
+
+	// ZK tracks the masters registered in Zookeeper, nil without zk
+	ZK *records.ZKDetector
+
+	// Sources are the sources of records besides the Mesos masters,
+	// refreshed along with them
+	Sources []records.RecordSource
+
+	// Updates stores the records added by dynamic updates, nil when
+	// updates are disabled; it is one of the Sources
+	Updates *records.UpdateStore
+}
+
+// New returns a Resolver for config that serves no records until the
+// first Reload
+func New(config records.Config) *Resolver {
+	return &Resolver{
+		rs:      &records.RecordGenerator{},
+		watch:   newWatchHub(),
+		limiter: newRateLimiter(config.RateLimit),
+
+		cfg:          &config,
+		acls:         newACLs(config.ACL),
+		reconfigured: make(chan struct{}, 1),
+
+		views:         newViews(config.Views),
+		ecsForwarders: parseACL(config.ECSForwarders),
+		triggers:      make(chan string, 1),
+		status:        refreshStatus{started: time.Now()},
+	}
 }
 
-// Reload triggers a new refresh from mesos master
+// logQuery adds the answer m to query r to the query log, if the query
+// is sampled
+func (res *Resolver) logQuery(w dns.ResponseWriter, r, m *dns.Msg, start time.Time, source string) {
+	if !res.QueryLog.Sampled() {
+		return
+	}
+
+	e := logging.QueryEntry{
+		Time:    start,
+		Proto:   "udp",
+		Rcode:   rcodeString(m.Rcode),
+		Answers: len(m.Answer),
+		Latency: float64(time.Since(start)) / float64(time.Millisecond),
+		Source:  source,
+	}
+	switch addr := w.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		e.Proto = "tcp"
+		e.Client = addr.IP.String()
+	case *net.UDPAddr:
+		e.Client = addr.IP.String()
+	}
+	if len(r.Question) > 0 {
+		e.Name = r.Question[0].Name
+		e.Type = typeString(r.Question[0].Qtype)
+	}
+
+	res.QueryLog.Log(e)
+}
+
+// records returns the current generation of resource records
+func (res *Resolver) records() *records.RecordGenerator {
+	res.rsLock.RLock()
+	defer res.rsLock.RUnlock()
+	return res.rs
+}
+
+// Reload triggers a new refresh from mesos master and the other sources,
+// serving their records merged
 func (res *Resolver) Reload() {
-	t := records.RecordGenerator{}
-	err := t.ParseState(&res.Config)
+	start := time.Now()
+	mesos := records.NewMesosSource(res.Masters, res.ZK, res.config())
+	err := res.refreshMesos(mesos)
+
+	refreshed := err == nil
+	for _, src := range res.Sources {
+		if err := res.refreshSource(src); err != nil {
+			logging.Error.Printf("cannot refresh source %s: %v\n", src.Name(), err)
+		} else {
+			refreshed = true
+		}
+	}
 
 	if err == nil {
-		res.rs = t
+		t := mesos.Generation()
+		res.merge()
+		refreshDuration.Observe(time.Since(start).Seconds())
+		lastRefresh.Set(float64(time.Now().Unix()))
+		res.status.succeeded(time.Now())
+
+		if path := res.config().SnapshotFile; path != "" {
+			if err := t.WriteSnapshot(path, res.config().Domain, time.Now()); err != nil {
+				logging.Error.Println("cannot write snapshot: ", err)
+			}
+		}
 	} else {
+		if refreshed {
+			res.merge()
+		}
+		refreshFailures.Inc()
+		res.status.failed(err)
 		logging.VeryVerbose.Println("Warning: master not found; keeping old DNS state")
 	}
+	res.status.isStale(time.Now(), res.maxStale())
+}
+
+// install makes t the generation of records that is served
+func (res *Resolver) install(t *records.RecordGenerator) {
+	projections := projectViews(res.views, t)
+
+	res.rsLock.Lock()
+	prev := res.rs
+	res.rs = t
+	res.projections = projections
+	res.rsLock.Unlock()
+	res.watch.publish(t)
+
+	observeRecords(prev, t, res.config().Domain)
+}
+
+// LoadSnapshot serves the records persisted in the snapshot file until
+// the first refresh succeeds
+func (res *Resolver) LoadSnapshot() error {
+	t, created, err := records.ReadSnapshot(res.config().SnapshotFile, res.config().Domain)
+	if err != nil {
+		return err
+	}
+	res.restoreMesos(t, created)
+	res.merge()
+	res.status.restored(created)
+	logging.Verbose.Println("serving records of snapshot from ", created)
+	return nil
 }
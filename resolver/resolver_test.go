@@ -78,9 +78,8 @@ func TestShuffleAnswers(t *testing.T) {
 	}
 }
 
-func fakeDNS(port int) (Resolver, error) {
-	var res Resolver
-	res.Config = records.Config{
+func fakeDNS(port int) (*Resolver, error) {
+	res := New(records.Config{
 		TTL:       60,
 		Port:      port,
 		Domain:    "mesos",
@@ -88,7 +87,7 @@ func fakeDNS(port int) (Resolver, error) {
 		Listener:  "127.0.0.1",
 		Email:     "root.mesos-dns.mesos.",
 		Mname:     "mesos-dns.mesos.",
-	}
+	})
 
 	b, err := ioutil.ReadFile("../factories/fake.json")
 	if err != nil {
@@ -102,8 +101,8 @@ func fakeDNS(port int) (Resolver, error) {
 	}
 
 	masters := []string{"144.76.157.37:5050"}
-	res.rs = records.RecordGenerator{}
-	res.rs.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", masters)
+	res.rs = &records.RecordGenerator{}
+	res.rs.InsertState(sj, "mesos", "mesos-dns.mesos.", []string{"127.0.0.1"}, masters)
 
 	return res, nil
 }
@@ -144,6 +143,15 @@ func identicalResults(msg_a []dns.RR, msg_b []dns.RR) bool {
 	return true
 }
 
+// serve serves queries on addr with the default handlers
+func serve(t *testing.T, res *Resolver, net, addr string) {
+	server, err := res.Listen(net, addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go res.Serve(server)
+}
+
 func TestHandler(t *testing.T) {
 	var msg []dns.RR
 
@@ -153,8 +161,8 @@ func TestHandler(t *testing.T) {
 	}
 
 	dns.HandleFunc("mesos.", res.HandleMesos)
-	go res.Serve("udp")
-	go res.Serve("tcp")
+	serve(t, res, "udp", "127.0.0.1:8053")
+	serve(t, res, "tcp", "127.0.0.1:8053")
 
 	// wait for startup ? lame
 	time.Sleep(10 * time.Millisecond)
@@ -251,8 +259,8 @@ func TestNonMesosHandler(t *testing.T) {
 	}
 
 	dns.HandleFunc(".", res.HandleNonMesos)
-	go res.Serve("udp")
-	go res.Serve("tcp")
+	serve(t, res, "udp", "127.0.0.1:8054")
+	serve(t, res, "tcp", "127.0.0.1:8054")
 
 	// wait for startup ? lame
 	time.Sleep(200 * time.Millisecond)
@@ -0,0 +1,404 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// kinds of the sockets passed on restarts, besides the dns ones that are
+// kept by network and address, like udp/127.0.0.1:53
+const (
+	socketHTTP  = "http"
+	socketReady = "ready"
+)
+
+// dnsSocket returns the kind of the dns socket of server
+func dnsSocket(server *dns.Server) string {
+	return server.Net + "/" + server.Addr
+}
+
+// restartTimeout bounds how long a restarted process may take to serve
+const restartTimeout = 3 * time.Minute
+
+// socket is a listening socket that can be passed to another process
+type socket interface {
+	File() (*os.File, error)
+}
+
+// lifecycle tracks the servers, so that they can be stopped, and their
+// sockets, so that they can be passed to a restarted process
+type lifecycle struct {
+	sync.Mutex
+	stopping  bool
+	dns       []*dns.Server
+	http      *http.Server
+	sockets   map[string]socket
+	inherited map[string]*os.File
+	ready     *os.File
+}
+
+// Inherit takes over the sockets passed by the process that restarted
+// into this one, or else by the init system with socket activation, which
+// are then served instead of new ones
+func (res *Resolver) Inherit() error {
+	list := os.Getenv(records.EnvSockets)
+	if list == "" {
+		return res.inheritActivated()
+	}
+	os.Unsetenv(records.EnvSockets)
+
+	res.life.Lock()
+	defer res.life.Unlock()
+	res.life.inherited = make(map[string]*os.File)
+	for i, kind := range strings.Split(list, ",") {
+		// the passed files follow stdin, stdout and stderr
+		f := os.NewFile(uintptr(3+i), kind)
+		if f == nil {
+			return fmt.Errorf("missing inherited socket %s", kind)
+		}
+		if kind == socketReady {
+			res.life.ready = f
+		} else {
+			res.life.inherited[kind] = f
+		}
+	}
+	logging.Verbose.Println("inherited sockets: ", list)
+	return nil
+}
+
+// inheritActivated takes over the sockets passed by systemd, or any init
+// system following its protocol. They are served by the listeners of their
+// address; a socket named http is served by the HTTP API.
+func (res *Resolver) inheritActivated() error {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	if fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	res.life.Lock()
+	defer res.life.Unlock()
+	res.life.inherited = make(map[string]*os.File)
+	var kinds []string
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(3+i), "activated")
+		kind, err := socketKind(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("socket %d passed by the init system: %v", 3+i, err)
+		}
+		if name := strings.Split(names, ":"); i < len(name) && name[i] == socketHTTP {
+			kind = socketHTTP
+		}
+		res.life.inherited[kind] = f
+		kinds = append(kinds, kind)
+	}
+	logging.Verbose.Println("sockets passed by the init system: ", strings.Join(kinds, ","))
+	return nil
+}
+
+// socketKind returns the kind of a socket passed by the init system: its
+// network and local address, like udp/127.0.0.1:53
+func socketKind(f *os.File) (string, error) {
+	if l, err := net.FileListener(f); err == nil {
+		defer l.Close()
+		return l.Addr().Network() + "/" + l.Addr().String(), nil
+	}
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return "", errors.New("not a listening socket")
+	}
+	defer conn.Close()
+	return conn.LocalAddr().Network() + "/" + conn.LocalAddr().String(), nil
+}
+
+// Ready tells the process that restarted into this one that queries are
+// served, so that it can stop
+func (res *Resolver) Ready() {
+	res.life.Lock()
+	defer res.life.Unlock()
+	if res.life.ready == nil {
+		return
+	}
+	if _, err := res.life.ready.Write([]byte{1}); err != nil {
+		logging.Error.Println("cannot signal readiness: ", err)
+	}
+	res.life.ready.Close()
+	res.life.ready = nil
+}
+
+// inherit returns the inherited socket of kind, if any
+func (res *Resolver) inherit(kind string) *os.File {
+	res.life.Lock()
+	defer res.life.Unlock()
+	f := res.life.inherited[kind]
+	delete(res.life.inherited, kind)
+	return f
+}
+
+// listen sets up the socket of server, inherited or new
+func (res *Resolver) listen(server *dns.Server) error {
+	kind := dnsSocket(server)
+	f := res.inherit(kind)
+	var s socket
+	switch server.Net {
+	case "udp":
+		var conn net.PacketConn
+		var err error
+		if f != nil {
+			conn, err = net.FilePacketConn(f)
+			f.Close()
+		} else {
+			conn, err = net.ListenPacket(server.Net, server.Addr)
+		}
+		if err != nil {
+			return err
+		}
+		udp, ok := conn.(*net.UDPConn)
+		if !ok {
+			conn.Close()
+			return errors.New("not a udp socket")
+		}
+		server.PacketConn, s = udp, udp
+	case "tcp":
+		l, err := res.listenTCP(f, server.Addr)
+		if err != nil {
+			return err
+		}
+		server.Listener, s = l, l
+	default:
+		return fmt.Errorf("unknown network %s", server.Net)
+	}
+
+	res.life.Lock()
+	defer res.life.Unlock()
+	res.addSocket(kind, s)
+	return nil
+}
+
+// activate registers server to be shut down, unless shutting down already,
+// in which case its socket is closed. Servers must only be shut down once
+// started, which they are right after.
+func (res *Resolver) activate(server *dns.Server) bool {
+	res.life.Lock()
+	defer res.life.Unlock()
+	if res.life.stopping {
+		if server.PacketConn != nil {
+			server.PacketConn.Close()
+		}
+		if server.Listener != nil {
+			server.Listener.Close()
+		}
+		return false
+	}
+	res.life.dns = append(res.life.dns, server)
+	return true
+}
+
+// listenTCP returns the listener of the inherited socket f, or else a new
+// listener on addr
+func (res *Resolver) listenTCP(f *os.File, addr string) (*net.TCPListener, error) {
+	var l net.Listener
+	var err error
+	if f != nil {
+		l, err = net.FileListener(f)
+		f.Close()
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+		return nil, errors.New("not a tcp socket")
+	}
+	return tl, nil
+}
+
+// addSocket records the socket of kind; the lifecycle must be locked
+func (res *Resolver) addSocket(kind string, s socket) {
+	if res.life.sockets == nil {
+		res.life.sockets = make(map[string]socket)
+	}
+	res.life.sockets[kind] = s
+}
+
+// stopping tells whether the servers are being stopped on purpose
+func (res *Resolver) stopping() bool {
+	res.life.Lock()
+	defer res.life.Unlock()
+	return res.life.stopping
+}
+
+// begin counts a query in flight until the returned func is called
+func (res *Resolver) begin() func() {
+	atomic.AddInt64(&res.inflight, 1)
+	return func() { atomic.AddInt64(&res.inflight, -1) }
+}
+
+// Shutdown stops accepting queries and HTTP requests, waits up to
+// ShutdownSeconds for the ones in flight and then persists what is kept
+// across restarts: the snapshot of the records and the query log
+func (res *Resolver) Shutdown() {
+	deadline := time.Now().Add(time.Duration(res.config().ShutdownSeconds) * time.Second)
+
+	res.life.Lock()
+	res.life.stopping = true
+	servers, httpServer := res.life.dns, res.life.http
+	res.life.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, s := range servers {
+			wg.Add(1)
+			go func(s *dns.Server) {
+				defer wg.Done()
+				if err := s.Shutdown(); err != nil {
+					logging.VeryVerbose.Println("shutdown: ", err)
+				}
+			}(s)
+		}
+		if httpServer != nil {
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			if err := httpServer.Shutdown(ctx); err != nil {
+				httpServer.Close()
+			}
+			cancel()
+		}
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(deadline)):
+	}
+	for atomic.LoadInt64(&res.inflight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&res.inflight); n > 0 {
+		logging.Error.Printf("shutdown: gave up on %d queries in flight\n", n)
+	}
+
+	res.ZK.Stop()
+	res.flushSnapshot()
+	if err := res.QueryLog.Close(); err != nil {
+		logging.Error.Println("cannot close query log: ", err)
+	}
+	logging.Verbose.Println("shut down")
+}
+
+// flushSnapshot persists the records of the last successful refresh
+func (res *Resolver) flushSnapshot() {
+	path := res.config().SnapshotFile
+	res.status.Lock()
+	created, restored := res.status.lastSuccess, res.status.snapshot
+	res.status.Unlock()
+	if path == "" || created.IsZero() || restored {
+		return
+	}
+	t := res.mesosGeneration()
+	if t == nil {
+		return
+	}
+	if err := t.WriteSnapshot(path, res.config().Domain, created); err != nil {
+		logging.Error.Println("cannot write snapshot: ", err)
+	}
+}
+
+// Restart starts a new process of the same executable and arguments,
+// passing it the listening sockets, and returns once the new process
+// serves queries. The caller is then expected to shut down.
+func (res *Resolver) Restart() error {
+	res.flushSnapshot()
+
+	res.life.Lock()
+	kinds := make([]string, 0, len(res.life.sockets))
+	for kind := range res.life.sockets {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, kind := range kinds {
+		f, err := res.life.sockets[kind].File()
+		if err != nil {
+			res.life.Unlock()
+			return fmt.Errorf("cannot pass %s socket: %v", kind, err)
+		}
+		files = append(files, f)
+	}
+	res.life.Unlock()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, records.EnvSockets+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, records.EnvSockets+"="+strings.Join(append(kinds, socketReady), ","))
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+		if err != nil {
+			return fmt.Errorf("new process %d exited before serving", cmd.Process.Pid)
+		}
+	case <-time.After(restartTimeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("new process %d didn't serve within %v", cmd.Process.Pid, restartTimeout)
+	}
+	logging.Verbose.Printf("new process %d serves queries\n", cmd.Process.Pid)
+	return nil
+}
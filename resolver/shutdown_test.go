@@ -0,0 +1,179 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// servedSocket waits for the socket of kind to be served
+func servedSocket(t *testing.T, res *Resolver, kind string) socket {
+	for i := 0; i < 100; i++ {
+		res.life.Lock()
+		s := res.life.sockets[kind]
+		res.life.Unlock()
+		if s != nil {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("not serving ", kind)
+	return nil
+}
+
+// servedServer waits for server to be registered by Serve and to answer
+// queries, so that it can be shut down
+func servedServer(t *testing.T, res *Resolver, server *dns.Server) {
+	registered := false
+	for i := 0; i < 100 && !registered; i++ {
+		res.life.Lock()
+		for _, s := range res.life.dns {
+			registered = registered || s == server
+		}
+		res.life.Unlock()
+		if !registered {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if !registered {
+		t.Fatal("not serving ", dnsSocket(server))
+	}
+
+	// the server is started once it answers
+	addr := server.Addr
+	if server.Listener != nil {
+		addr = server.Listener.Addr().String()
+	} else if server.PacketConn != nil {
+		addr = server.PacketConn.LocalAddr().String()
+	}
+	r := new(dns.Msg)
+	r.SetQuestion("served.", dns.TypeA)
+	c := &dns.Client{Net: server.Net}
+	for i := 0; i < 100; i++ {
+		if _, _, err := c.Exchange(r, addr); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no answer from ", dnsSocket(server))
+}
+
+func TestShutdown(t *testing.T) {
+	f, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	defer os.Remove(f.Name())
+
+	res := New(records.Config{Domain: "mesos", ShutdownSeconds: 5, SnapshotFile: f.Name()})
+	res.srcs.mesos = &records.RecordGenerator{}
+	res.status.succeeded(time.Now())
+	server, err := res.Listen("tcp", "127.0.0.1:0", dns.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan struct{})
+	go func() {
+		res.Serve(server)
+		close(served)
+	}()
+	servedServer(t, res, server)
+
+	done := res.begin()
+	stopped := make(chan struct{})
+	go func() {
+		res.Shutdown()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("shutdown should wait for queries in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	done()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown should return once queries are done")
+	}
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Error("the server should stop")
+	}
+	if _, err := os.Stat(f.Name()); err != nil {
+		t.Error("shutdown should write the snapshot: ", err)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	res := New(records.Config{ShutdownSeconds: 0})
+	res.begin()
+
+	start := time.Now()
+	res.Shutdown()
+	if time.Since(start) > time.Second {
+		t.Error("shutdown should give up on queries in flight after the deadline")
+	}
+}
+
+func TestInheritedSocket(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	f, err := conn.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := New(records.Config{ShutdownSeconds: 1})
+	res.life.inherited = map[string]*os.File{"udp/127.0.0.1:53": f}
+	server, err := res.Listen("udp", "127.0.0.1:53", dns.NewServeMux())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go res.Serve(server)
+	s := servedSocket(t, res, "udp/127.0.0.1:53")
+	defer res.Shutdown()
+
+	if got := s.(*net.UDPConn).LocalAddr().String(); got != conn.LocalAddr().String() {
+		t.Errorf("should serve the inherited socket %s, got %s", conn.LocalAddr(), got)
+	}
+}
+
+func TestSocketKind(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for want, s := range map[string]socket{
+		"udp/" + conn.LocalAddr().String(): conn.(*net.UDPConn),
+		"tcp/" + l.Addr().String():         l.(*net.TCPListener),
+	} {
+		f, err := s.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := socketKind(f); err != nil || got != want {
+			t.Errorf("should be %s, got %s (%v)", want, got, err)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,256 @@
+package resolver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+var (
+	sourceRecords = metrics.NewGaugeVec("mesos_dns_source_records",
+		"Records yielded by every source, by source.", "source")
+	sourceShadowed = metrics.NewGaugeVec("mesos_dns_source_shadowed",
+		"Records of every source not served because a source of higher precedence has the name, by source.", "source")
+	sourceFailures = metrics.NewCounterVec("mesos_dns_source_failures_total",
+		"Failed refreshes of every source, by source.", "source")
+)
+
+// sourceState is what is known of a source: the records of its last
+// successful refresh, and how refreshing it went since
+type sourceState struct {
+	records     []records.Record
+	gen         *records.RecordGenerator // instead of records, for mesos
+	lastSuccess time.Time
+	failures    int
+	lastError   string
+	shadowed    int
+}
+
+// sources are the states of the sources, in the order they were first
+// refreshed, along with the last generation of the mesos source
+type sources struct {
+	order  []string
+	states map[string]*sourceState
+	mesos  *records.RecordGenerator
+}
+
+// sourceReport is the status of a source as served by /v1/sources
+type sourceReport struct {
+	Name        string     `json:"name"`
+	Records     int        `json:"records"`
+	Shadowed    int        `json:"shadowed"`
+	LastSuccess *time.Time `json:"last_success"`
+	Failures    int        `json:"consecutive_failures"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// sourcesReport are the sources by precedence as served by /v1/sources
+type sourcesReport struct {
+	Conflicts string         `json:"conflicts"`
+	Sources   []sourceReport `json:"sources"`
+}
+
+// source returns the state of the source name; sources must be locked
+func (res *Resolver) source(name string) *sourceState {
+	if res.srcs.states == nil {
+		res.srcs.states = make(map[string]*sourceState)
+	}
+	st, ok := res.srcs.states[name]
+	if !ok {
+		st = &sourceState{}
+		res.srcs.states[name] = st
+		res.srcs.order = append(res.srcs.order, name)
+	}
+	return st
+}
+
+// refreshSource gets the records of src, keeping its previous records if
+// that fails
+func (res *Resolver) refreshSource(src records.RecordSource) error {
+	recs, err := src.Records()
+	now := time.Now()
+
+	res.srcsLock.Lock()
+	defer res.srcsLock.Unlock()
+	st := res.source(src.Name())
+	if err != nil {
+		st.failures++
+		st.lastError = err.Error()
+		sourceFailures.With(src.Name()).Inc()
+		return err
+	}
+	st.records, st.lastSuccess = recs, now
+	st.failures, st.lastError = 0, ""
+	sourceRecords.With(src.Name()).Set(float64(len(recs)))
+	return nil
+}
+
+// refreshMesos generates the records of the mesos source, keeping the
+// previous generation if that fails
+func (res *Resolver) refreshMesos(mesos *records.MesosSource) error {
+	t, err := mesos.Generate()
+	now := time.Now()
+
+	res.srcsLock.Lock()
+	defer res.srcsLock.Unlock()
+	st := res.source(records.SourceMesos)
+	if err != nil {
+		st.failures++
+		st.lastError = err.Error()
+		sourceFailures.With(records.SourceMesos).Inc()
+		return err
+	}
+	st.gen, st.lastSuccess = t, now
+	st.failures, st.lastError = 0, ""
+	res.srcs.mesos = t
+	sourceRecords.With(records.SourceMesos).Set(float64(t.Len()))
+	return nil
+}
+
+// set returns the records of the state as set of source
+func (st *sourceState) set(source string) records.RecordSet {
+	return records.RecordSet{Source: source, Records: st.records, Generation: st.gen}
+}
+
+// watchedSource is a source that tells whether its records changed since
+// they were last got
+type watchedSource interface {
+	records.RecordSource
+	Changed() bool
+}
+
+// RefreshSources gets the records of the sources besides the Mesos
+// masters, serving them along with the current ones of the masters
+func (res *Resolver) RefreshSources() {
+	for _, src := range res.Sources {
+		if err := res.refreshSource(src); err != nil {
+			logging.Error.Printf("cannot refresh source %s: %v\n", src.Name(), err)
+		}
+	}
+	res.merge()
+}
+
+// sourcePoll is how often watched sources are checked for changes
+const sourcePoll = time.Second
+
+// WatchSources refreshes the sources whose records changed, like edited
+// zone files or expired leases, as soon as they are seen. It never
+// returns.
+func (res *Resolver) WatchSources() {
+	res.sourceLoop(sourcePoll, nil)
+}
+
+// sourceLoop checks the sources every poll until stop is closed
+func (res *Resolver) sourceLoop(poll time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			res.refreshChanged()
+		}
+	}
+}
+
+// refreshChanged refreshes the sources whose records changed, like zone
+// files that were edited, without waiting for the next refresh
+func (res *Resolver) refreshChanged() {
+	changed := false
+	for _, src := range res.Sources {
+		ws, ok := src.(watchedSource)
+		if !ok || !ws.Changed() {
+			continue
+		}
+		logging.Verbose.Printf("source %s changed, refreshing\n", src.Name())
+		if err := res.refreshSource(src); err != nil {
+			logging.Error.Printf("cannot refresh source %s: %v\n", src.Name(), err)
+		} else {
+			changed = true
+		}
+	}
+	if changed {
+		res.merge()
+	}
+}
+
+// restoreMesos makes the mesos source start out with the records of a
+// snapshot created at created
+func (res *Resolver) restoreMesos(t *records.RecordGenerator, created time.Time) {
+	res.srcsLock.Lock()
+	defer res.srcsLock.Unlock()
+	st := res.source(records.SourceMesos)
+	st.gen, st.lastSuccess = t, created
+	res.srcs.mesos = t
+}
+
+// merge serves the records of every source, merged by precedence
+func (res *Resolver) merge() {
+	res.mergeLock.Lock()
+	defer res.mergeLock.Unlock()
+	conf := res.config().Sources
+
+	res.srcsLock.Lock()
+	order := conf.Order(res.srcs.order)
+	sets := make([]records.RecordSet, len(order))
+	for i, name := range order {
+		sets[i] = res.srcs.states[name].set(name)
+	}
+	var slaves records.Slaves
+	if res.srcs.mesos != nil {
+		slaves = res.srcs.mesos.Slaves
+	}
+	res.srcsLock.Unlock()
+
+	t, shadowed := records.Merge(sets, conf.Conflicts)
+	t.Slaves = slaves
+
+	res.srcsLock.Lock()
+	for name, n := range shadowed {
+		res.srcs.states[name].shadowed = n
+		sourceShadowed.With(name).Set(float64(n))
+	}
+	res.srcsLock.Unlock()
+
+	res.install(t)
+}
+
+// mesosGeneration returns the last generation of the mesos source, nil
+// before the first
+func (res *Resolver) mesosGeneration() *records.RecordGenerator {
+	res.srcsLock.Lock()
+	defer res.srcsLock.Unlock()
+	return res.srcs.mesos
+}
+
+// HandleSources serves the status of every source as JSON, by precedence
+func (res *Resolver) HandleSources(w http.ResponseWriter, r *http.Request) {
+	conf := res.config().Sources
+
+	res.srcsLock.Lock()
+	rep := sourcesReport{Conflicts: conf.Conflicts, Sources: []sourceReport{}}
+	for _, name := range conf.Order(res.srcs.order) {
+		st := res.srcs.states[name]
+		sr := sourceReport{
+			Name:      name,
+			Records:   st.set(name).Len(),
+			Shadowed:  st.shadowed,
+			Failures:  st.failures,
+			LastError: st.lastError,
+		}
+		if !st.lastSuccess.IsZero() {
+			last := st.lastSuccess
+			sr.LastSuccess = &last
+		}
+		rep.Sources = append(rep.Sources, sr)
+	}
+	res.srcsLock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rep)
+}
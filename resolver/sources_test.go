@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// fakeSource is a record source yielding fixed records
+type fakeSource struct {
+	name string
+	recs []records.Record
+	err  error
+}
+
+func (s *fakeSource) Name() string                       { return s.name }
+func (s *fakeSource) Records() ([]records.Record, error) { return s.recs, s.err }
+
+func TestSources(t *testing.T) {
+	res := New(records.Config{Domain: "mesos", Sources: records.SourcesConfig{Conflicts: records.ConflictPrecedence}})
+	mesos := &fakeSource{name: records.SourceMesos, recs: []records.Record{
+		{Name: "web.mesos.", Type: records.TypeA, Target: "10.0.0.1", Source: records.SourceMesos},
+	}}
+	static := &fakeSource{name: "static", recs: []records.Record{
+		{Name: "web.mesos.", Type: records.TypeA, Target: "10.0.0.9", Source: "static"},
+		{Name: "db.mesos.", Type: records.TypeA, Target: "10.0.0.2", Source: "static"},
+	}}
+	for _, src := range []records.RecordSource{mesos, static} {
+		if err := res.refreshSource(src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res.merge()
+
+	if got := res.records().As; len(got["web.mesos."]) != 1 || got["web.mesos."][0] != "10.0.0.1" || len(got["db.mesos."]) != 1 {
+		t.Error("should merge the sources by precedence, got ", got)
+	}
+
+	// a failing source keeps its records
+	static.err = errors.New("unreadable")
+	res.refreshSource(static)
+	res.merge()
+	if len(res.records().As["db.mesos."]) != 1 {
+		t.Error("should keep the records of a failing source")
+	}
+
+	w := httptest.NewRecorder()
+	res.HandleSources(w, &http.Request{Method: "GET"})
+	var rep sourcesReport
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Sources) != 2 || rep.Sources[1].Name != "static" || rep.Sources[1].Shadowed != 1 ||
+		rep.Sources[1].Failures != 1 || rep.Sources[1].LastError != "unreadable" || rep.Sources[0].Records != 1 {
+		t.Errorf("unexpected report %+v", rep)
+	}
+}
+
+func TestCNAME(t *testing.T) {
+	res := New(records.Config{Domain: "mesos", TTL: 60})
+	res.install(&records.RecordGenerator{
+		As:     map[string][]string{"registry.marathon.mesos.": {"10.0.0.1"}},
+		CNAMEs: map[string][]string{"registry.mesos.": {"registry.marathon.mesos."}, "db.mesos.": {"db.example.com."}},
+	})
+
+	r := new(dns.Msg)
+	r.SetQuestion("registry.mesos.", dns.TypeA)
+	w := udpClient("127.0.0.1")
+	res.HandleMesos(w, r)
+	if w.msg == nil || len(w.msg.Answer) != 2 || w.msg.Answer[0].Header().Rrtype != dns.TypeCNAME {
+		t.Fatal("should answer the CNAME followed by the A records of its target, got ", w.msg)
+	}
+
+	r.SetQuestion("db.mesos.", dns.TypeAAAA)
+	res.HandleMesos(w, r)
+	if w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) != 1 || w.msg.Answer[0].(*dns.CNAME).Target != "db.example.com." {
+		t.Error("should answer the CNAME for any type, got ", w.msg)
+	}
+}
+
+// changingSource is a fake source telling whether it changed
+type changingSource struct {
+	fakeSource
+	sync.Mutex
+	changed bool
+}
+
+func (s *changingSource) Changed() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.changed
+}
+
+func (s *changingSource) Records() ([]records.Record, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.changed = false
+	return s.recs, nil
+}
+
+func TestWatchSources(t *testing.T) {
+	res := New(records.Config{Domain: "mesos", Sources: records.SourcesConfig{Conflicts: records.ConflictPrecedence}})
+	src := &changingSource{fakeSource: fakeSource{name: "static"}}
+	res.Sources = []records.RecordSource{src}
+	stop := make(chan struct{})
+	defer close(stop)
+	go res.sourceLoop(5*time.Millisecond, stop)
+
+	src.Lock()
+	src.recs = []records.Record{{Name: "db.mesos.", Type: records.TypeA, Target: "10.0.0.2", Source: "static"}}
+	src.changed = true
+	src.Unlock()
+	for i := 0; i < 100 && len(res.records().As["db.mesos."]) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(res.records().As["db.mesos."]) != 1 {
+		t.Error("should serve the records of a changed source without waiting for a refresh")
+	}
+}
@@ -0,0 +1,230 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+var (
+	staleRecords = metrics.NewGauge("mesos_dns_stale",
+		"1 while the records are older than the configured maximum staleness.")
+	refreshFailing = metrics.NewGauge("mesos_dns_refresh_consecutive_failures",
+		"Refreshes that failed since the last successful one.")
+	fromSnapshot = metrics.NewGauge("mesos_dns_snapshot",
+		"1 while the records come from the snapshot loaded on startup.")
+)
+
+// refreshStatus tracks the outcome of refreshes, telling how old the
+// served records are
+type refreshStatus struct {
+	sync.Mutex
+	started     time.Time
+	lastSuccess time.Time
+	failures    int
+	lastError   string
+	stale       bool
+	snapshot    bool
+}
+
+// statusReport is the refresh status as served by /v1/status
+type statusReport struct {
+	State       string     `json:"state"`
+	Source      string     `json:"source"`
+	Policy      string     `json:"policy"`
+	LastSuccess *time.Time `json:"last_success"`
+	Age         float64    `json:"age_seconds"`
+	Failures    int        `json:"consecutive_failures"`
+	LastError   string     `json:"last_error,omitempty"`
+
+	// RestartPending lists the changed settings waiting for a restart
+	RestartPending []string `json:"restart_pending,omitempty"`
+}
+
+// succeeded records a successful refresh at now
+func (s *refreshStatus) succeeded(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.lastSuccess = now
+	s.failures = 0
+	s.lastError = ""
+	s.snapshot = false
+	refreshFailing.Set(0)
+	fromSnapshot.Set(0)
+}
+
+// restored records that the records were loaded from a snapshot of
+// records created at created
+func (s *refreshStatus) restored(created time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.lastSuccess = created
+	s.snapshot = true
+	fromSnapshot.Set(1)
+}
+
+// failed records a failed refresh
+func (s *refreshStatus) failed(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.failures++
+	s.lastError = err.Error()
+	refreshFailing.Set(float64(s.failures))
+}
+
+// age returns how old the records are at now; without any successful
+// refresh they are as old as the resolver
+func (s *refreshStatus) age(now time.Time) time.Duration {
+	if s.lastSuccess.IsZero() {
+		return now.Sub(s.started)
+	}
+	return now.Sub(s.lastSuccess)
+}
+
+// isStale tells whether the records are older than max at now; a zero max
+// means records never go stale. Changes of the staleness are logged.
+func (s *refreshStatus) isStale(now time.Time, max time.Duration) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	stale := max > 0 && s.age(now) > max
+	if stale != s.stale {
+		s.stale = stale
+		if stale {
+			staleRecords.Set(1)
+			logging.Error.Printf("records are stale: no successful refresh for %v (%s)\n", max, s.lastError)
+		} else {
+			staleRecords.Set(0)
+			logging.Verbose.Println("records are fresh again")
+		}
+	}
+	return stale
+}
+
+// report returns the status at now for records that go stale after max
+func (s *refreshStatus) report(now time.Time, max time.Duration, policy string) statusReport {
+	stale := s.isStale(now, max)
+
+	s.Lock()
+	defer s.Unlock()
+	r := statusReport{
+		State:     "ok",
+		Source:    "mesos",
+		Policy:    policy,
+		Age:       s.age(now).Seconds(),
+		Failures:  s.failures,
+		LastError: s.lastError,
+	}
+	if s.snapshot {
+		r.Source = "snapshot"
+	}
+	// snapshot records are stale until confirmed by the masters
+	if stale || s.snapshot {
+		r.State = "stale"
+	}
+	if !s.lastSuccess.IsZero() {
+		last := s.lastSuccess
+		r.LastSuccess = &last
+	}
+	return r
+}
+
+// maxStale returns the configured maximum staleness
+func (res *Resolver) maxStale() time.Duration {
+	return time.Duration(res.config().Stale.MaxSeconds) * time.Second
+}
+
+// servesStale tells whether the answers at now are built from stale
+// records, older than max, and whether those come from a snapshot not
+// confirmed by the masters yet
+func (s *refreshStatus) servesStale(now time.Time, max time.Duration) (stale, snapshot bool) {
+	stale = s.isStale(now, max)
+	s.Lock()
+	defer s.Unlock()
+	return stale, s.snapshot
+}
+
+// fromMesos tells whether the answers for name depend on the records of
+// the mesos source, unlike those of names only served by other sources
+// such as zone files and updates
+func (res *Resolver) fromMesos(name string) bool {
+	if !inUse(res.records(), name) {
+		return true
+	}
+	t := res.mesosGeneration()
+	return t != nil && inUse(t, name)
+}
+
+// staleAnswer applies the stale policy to the answer m to r, returning
+// the message to send instead. Only answers built from the records of the
+// mesos source can be stale. Records of a snapshot are served marked
+// stale until the masters confirm them, even under the servfail policy.
+func (res *Resolver) staleAnswer(r, m *dns.Msg) *dns.Msg {
+	stale, snapshot := res.status.servesStale(time.Now(), res.maxStale())
+	if !stale && !snapshot || !res.fromMesos(strings.ToLower(r.Question[0].Name)) {
+		return m
+	}
+
+	policy := res.config().Stale.Policy
+	if snapshot && policy == records.StaleServfail {
+		policy = records.StaleTTL
+	}
+	switch policy {
+	case records.StaleServfail:
+		m = new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+	case records.StaleTTL:
+		floor := uint32(res.config().Stale.FloorTTL)
+		for _, rrs := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+			for _, rr := range rrs {
+				if h := rr.Header(); h.Ttl > floor {
+					h.Ttl = floor
+				}
+			}
+		}
+	}
+	return m
+}
+
+// statusName returns the name of the status TXT record
+func (res *Resolver) statusName() string {
+	return "_status." + res.config().Domain + "."
+}
+
+// statusTXT returns the refresh status as TXT record for name
+func (res *Resolver) statusTXT(name string) *dns.TXT {
+	rep := res.status.report(time.Now(), res.maxStale(), res.config().Stale.Policy)
+	txt := []string{
+		"state=" + rep.State,
+		fmt.Sprintf("age=%d", int64(rep.Age)),
+		"failures=" + strconv.Itoa(rep.Failures),
+		"source=" + rep.Source,
+	}
+	if rep.LastSuccess != nil {
+		txt = append(txt, "last_success="+rep.LastSuccess.UTC().Format(time.RFC3339))
+	}
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+		Txt: txt,
+	}
+}
+
+// HandleStatus serves the refresh status as JSON
+func (res *Resolver) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	rep := res.status.report(time.Now(), res.maxStale(), res.config().Stale.Policy)
+	rep.RestartPending = res.restartPending()
+	w.Header().Set("Content-Type", "application/json")
+	if rep.State != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,267 @@
+package resolver
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+func staleResolver(policy string) *Resolver {
+	res := New(records.Config{
+		Domain: "mesos",
+		TTL:    60,
+		Stale:  records.StaleConfig{MaxSeconds: 60, Policy: policy, FloorTTL: 5, StatusRecord: true},
+	})
+	res.status.started = time.Now().Add(-time.Hour)
+	return res
+}
+
+func TestRefreshStatus(t *testing.T) {
+	now := time.Now()
+	s := refreshStatus{started: now.Add(-10 * time.Second)}
+
+	if !s.isStale(now, 5*time.Second) {
+		t.Error("records without a successful refresh should age from the start")
+	}
+	if s.isStale(now, 0) {
+		t.Error("records should never be stale without a maximum")
+	}
+
+	s.succeeded(now)
+	if s.isStale(now, 5*time.Second) {
+		t.Error("a successful refresh should make records fresh")
+	}
+
+	s.failed(errors.New("no master"))
+	s.failed(errors.New("no master"))
+	rep := s.report(now.Add(time.Minute), 5*time.Second, records.StaleServe)
+	if rep.State != "stale" || rep.Failures != 2 || rep.LastError != "no master" || rep.LastSuccess == nil {
+		t.Errorf("unexpected report %+v", rep)
+	}
+}
+
+func TestReloadWithoutClient(t *testing.T) {
+	res := New(records.Config{Domain: "mesos", Masters: []string{"10.0.0.1:5050"}})
+	res.Reload()
+
+	res.status.Lock()
+	defer res.status.Unlock()
+	if res.status.failures != 1 || res.status.lastError == "" {
+		t.Errorf("a refresh without a client should fail, got %d failures: %q", res.status.failures, res.status.lastError)
+	}
+}
+
+func TestStaleAnswer(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetQuestion("web.marathon.mesos.", dns.TypeA)
+	answer := func() *dns.Msg {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: "web.marathon.mesos.", Rrtype: dns.TypeA, Ttl: 60}})
+		return m
+	}
+
+	if m := staleResolver(records.StaleServe).staleAnswer(r, answer()); m.Answer[0].Header().Ttl != 60 {
+		t.Error("serve should answer stale records as usual")
+	}
+	if m := staleResolver(records.StaleTTL).staleAnswer(r, answer()); m.Answer[0].Header().Ttl != 5 {
+		t.Error("ttl should lower TTLs to the floor, got ", m.Answer[0].Header().Ttl)
+	}
+	if m := staleResolver(records.StaleServfail).staleAnswer(r, answer()); m.Rcode != dns.RcodeServerFailure || len(m.Answer) != 0 {
+		t.Error("servfail should fail stale answers")
+	}
+
+	res := staleResolver(records.StaleServfail)
+	res.status.succeeded(time.Now())
+	if m := res.staleAnswer(r, answer()); m.Rcode != dns.RcodeSuccess {
+		t.Error("fresh records should be answered")
+	}
+
+	// snapshot records are stale until confirmed, whatever their age
+	res = staleResolver(records.StaleTTL)
+	res.status.restored(time.Now())
+	if m := res.staleAnswer(r, answer()); m.Answer[0].Header().Ttl != 5 {
+		t.Error("answers from a snapshot should be stale, got TTL ", m.Answer[0].Header().Ttl)
+	}
+	res.status.succeeded(time.Now())
+	if m := res.staleAnswer(r, answer()); m.Answer[0].Header().Ttl != 60 {
+		t.Error("answers should be fresh once the masters confirm the records")
+	}
+
+	// servfail doesn't drop the records of a snapshot
+	res = staleResolver(records.StaleServfail)
+	res.status.restored(time.Now())
+	if m := res.staleAnswer(r, answer()); m.Rcode != dns.RcodeSuccess || m.Answer[0].Header().Ttl != 5 {
+		t.Error("answers from a snapshot should be served stale under servfail, got ", m)
+	}
+
+	// records of other sources never go stale
+	res = staleResolver(records.StaleServfail)
+	res.restoreMesos(&records.RecordGenerator{As: map[string][]string{"db.marathon.mesos.": {"10.0.0.2"}}}, time.Now())
+	res.install(&records.RecordGenerator{As: map[string][]string{
+		"db.marathon.mesos.":  {"10.0.0.2"},
+		"web.marathon.mesos.": {"10.0.0.1"},
+	}})
+	if m := res.staleAnswer(r, answer()); m.Rcode != dns.RcodeSuccess || m.Answer[0].Header().Ttl != 60 {
+		t.Error("answers from other sources should not be stale, got ", m)
+	}
+	r.SetQuestion("db.marathon.mesos.", dns.TypeA)
+	if m := res.staleAnswer(r, answer()); m.Rcode != dns.RcodeServerFailure {
+		t.Error("answers from the mesos source should be stale")
+	}
+}
+
+func TestStatusRecord(t *testing.T) {
+	res := staleResolver(records.StaleServfail)
+
+	r := new(dns.Msg)
+	r.SetQuestion("_status.mesos.", dns.TypeTXT)
+	w := udpClient("127.0.0.1")
+	res.HandleMesos(w, r)
+
+	if w.msg == nil || len(w.msg.Answer) != 1 {
+		t.Fatal("should answer the status record, even while stale")
+	}
+	txt := w.msg.Answer[0].(*dns.TXT).Txt
+	if !strings.Contains(strings.Join(txt, " "), "state=stale") {
+		t.Error("status should report stale records, got ", txt)
+	}
+}
+
+func TestHandleStatus(t *testing.T) {
+	res := staleResolver(records.StaleServe)
+	w := httptest.NewRecorder()
+	res.HandleStatus(w, &http.Request{Method: "GET"})
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Error("stale records should be reported as unavailable, got ", w.Code)
+	}
+	var rep statusReport
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.State != "stale" || rep.LastSuccess != nil {
+		t.Errorf("unexpected report %+v", rep)
+	}
+}
+
+func TestLoadSnapshot(t *testing.T) {
+	f, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	rg := &records.RecordGenerator{As: map[string][]string{"web.marathon.mesos.": {"10.0.0.1"}}}
+	if err = rg.WriteSnapshot(f.Name(), "mesos", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	res := New(records.Config{Domain: "mesos", SnapshotFile: f.Name()})
+	if err = res.LoadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.records().As["web.marathon.mesos."]) != 1 {
+		t.Error("should serve the records of the snapshot")
+	}
+	if rep := res.status.report(time.Now(), 0, records.StaleServe); rep.State != "stale" || rep.Source != "snapshot" {
+		t.Errorf("snapshot records should be marked stale, got %+v", rep)
+	}
+
+	res.status.succeeded(time.Now())
+	if rep := res.status.report(time.Now(), 0, records.StaleServe); rep.State != "ok" || rep.Source != "mesos" {
+		t.Errorf("a refresh should replace the snapshot, got %+v", rep)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	res := New(records.Config{Domain: "mesos", RefreshSeconds: 60})
+	now := time.Now()
+
+	rep := res.readiness(now)
+	if rep.Ready || len(rep.Reasons) != 2 {
+		t.Errorf("should not be ready before records are generated, got %+v", rep)
+	}
+
+	res.rs = &records.RecordGenerator{As: map[string][]string{"leader.mesos.": {"10.0.0.1"}}}
+	res.status.succeeded(now)
+	if rep = res.readiness(now.Add(time.Minute)); !rep.Ready {
+		t.Errorf("should be ready with fresh records and a leader, got %+v", rep)
+	}
+	if rep = res.readiness(now.Add(4 * time.Minute)); rep.Ready || rep.MaxAge != 180 {
+		t.Errorf("should not be ready beyond three refresh intervals, got %+v", rep)
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("_health.mesos.", dns.TypeTXT)
+	w := udpClient("127.0.0.1")
+	res.cfg.Health.Record = true
+	res.HandleMesos(w, r)
+	if w.msg == nil || len(w.msg.Answer) != 1 || w.msg.Answer[0].(*dns.TXT).Txt[0] != "ready" {
+		t.Error("should answer the health record, got ", w.msg)
+	}
+}
+
+func TestReadinessSnapshot(t *testing.T) {
+	f, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	rg := &records.RecordGenerator{As: map[string][]string{"leader.mesos.": {"10.0.0.1"}}}
+	if err = rg.WriteSnapshot(f.Name(), "mesos", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	res := New(records.Config{Domain: "mesos", RefreshSeconds: 60, SnapshotFile: f.Name()})
+	if err = res.LoadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if rep := res.readiness(time.Now()); rep.Ready || !rep.Leader {
+		t.Errorf("should not be ready with the records of a snapshot only, got %+v", rep)
+	}
+
+	res.status.succeeded(time.Now())
+	if rep := res.readiness(time.Now()); !rep.Ready {
+		t.Errorf("should be ready once a refresh succeeded, got %+v", rep)
+	}
+}
+
+func TestHandleHealth(t *testing.T) {
+	res := New(records.Config{Domain: "mesos", Listener: "127.0.0.1", Port: 53})
+	w := httptest.NewRecorder()
+	res.HandleHealth(w, &http.Request{Method: "GET"})
+
+	var rep healthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || len(rep.Missing) != 2 {
+		t.Errorf("listeners not served should be reported, got %d %+v", w.Code, rep)
+	}
+
+	res.life.dns = []*dns.Server{{Net: "udp", Addr: "127.0.0.1:53"}, {Net: "tcp", Addr: "127.0.0.1:53"}}
+	w = httptest.NewRecorder()
+	res.HandleHealth(w, &http.Request{Method: "GET"})
+	if w.Code != http.StatusOK {
+		t.Error("should be healthy with every listener served, got ", w.Code)
+	}
+
+	res.life.stopping = true
+	w = httptest.NewRecorder()
+	res.HandleHealth(w, &http.Request{Method: "GET"})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Error("should not be healthy while shutting down, got ", w.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package resolver
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// transferChunk is the number of records sent per message of a transfer
+const transferChunk = 100
+
+// handleTransfer answers AXFR and IXFR queries for the mesos zone with a
+// full transfer of the current records, over TCP and to clients on the
+// transfer acl only
+func (res *Resolver) handleTransfer(w dns.ResponseWriter, r *dns.Msg, start time.Time) {
+	zone := res.config().Domain + "."
+
+	if !res.access().transfer.allows(clientIP(w)) {
+		m := refuse(w, r, capTransfer)
+		observeQuery(zone, r, m, start, mesosLatency)
+		res.logQuery(w, r, m, start, logging.SourceLocal)
+		return
+	}
+
+	m := new(dns.Msg)
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); !ok {
+		m.SetRcode(r, dns.RcodeNotImplemented)
+	} else if !strings.EqualFold(r.Question[0].Name, zone) {
+		m.SetRcode(r, dns.RcodeNotAuth)
+	}
+	if m.Rcode != dns.RcodeSuccess {
+		observeQuery(zone, r, m, start, mesosLatency)
+		res.logQuery(w, r, m, start, logging.SourceLocal)
+		if err := w.WriteMsg(m); err != nil {
+			logging.Error.Println(err)
+		}
+		return
+	}
+
+	soa, _ := res.formatSOA(zone)
+	all := append([]dns.RR{soa}, res.zoneRecords()...)
+	all = append(all, soa)
+
+	// every chunk is a message of its own; the connection is closed once
+	// the zone was sent
+	w.Hijack()
+	defer w.Close()
+	for rrs := all; len(rrs) > 0; {
+		n := transferChunk
+		if n > len(rrs) {
+			n = len(rrs)
+		}
+		chunk := new(dns.Msg)
+		chunk.SetReply(r)
+		chunk.Authoritative = true
+		chunk.Answer = rrs[:n]
+		if err := w.WriteMsg(chunk); err != nil {
+			logging.Error.Println(err)
+			break
+		}
+		rrs = rrs[n:]
+	}
+
+	m.SetReply(r)
+	m.Answer = all
+	observeQuery(zone, r, m, start, mesosLatency)
+	res.logQuery(w, r, m, start, logging.SourceMesos)
+}
+
+// zoneRecords returns every A, CNAME and SRV record of the mesos zone, sorted
+// by name
+func (res *Resolver) zoneRecords() []dns.RR {
+	rs := res.records()
+
+	var rrs []dns.RR
+	for _, name := range sortedNames(rs.As) {
+		for _, host := range rs.As[name] {
+			rr, err := res.formatA(name, host)
+			if err != nil {
+				logging.Error.Println(err)
+				continue
+			}
+			rrs = append(rrs, rr)
+		}
+	}
+	for _, name := range sortedNames(rs.CNAMEs) {
+		for _, target := range rs.CNAMEs[name] {
+			rrs = append(rrs, res.formatCNAME(name, target))
+		}
+	}
+	for _, name := range sortedNames(rs.SRVs) {
+		for _, target := range rs.SRVs[name] {
+			rr, err := res.formatSRV(name, target)
+			if err != nil {
+				logging.Error.Println(err)
+				continue
+			}
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+func sortedNames(rrs map[string][]string) []string {
+	names := make([]string, 0, len(rrs))
+	for name := range rrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,54 @@
+package resolver
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+func TestTransfer(t *testing.T) {
+	res := New(records.Config{Domain: "mesos", TTL: 60, Email: "root.mesos-dns.mesos.", Mname: "mesos-dns.mesos."})
+	as := make(map[string][]string)
+	const n = 3*transferChunk + 7
+	for i := 0; i < n; i++ {
+		as[fmt.Sprintf("task%d.marathon.mesos.", i)] = []string{fmt.Sprintf("10.0.%d.%d", i/256, i%256)}
+	}
+	res.install(&records.RecordGenerator{As: as})
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc("mesos.", res.HandleMesos)
+	server, err := res.Listen("tcp", "127.0.0.1:0", mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go res.Serve(server)
+	servedServer(t, res, server)
+
+	r := new(dns.Msg)
+	r.SetAxfr("mesos.")
+	envs, err := new(dns.Transfer).In(r, server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, soas := 0, 0
+	for env := range envs {
+		if env.Error != nil {
+			t.Fatal(env.Error)
+		}
+		if len(env.RR) > transferChunk {
+			t.Errorf("messages should hold at most %d records, got %d", transferChunk, len(env.RR))
+		}
+		for _, rr := range env.RR {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				soas++
+			} else {
+				got++
+			}
+		}
+	}
+	if got != n || soas != 2 {
+		t.Errorf("should transfer %d records between two SOA records, got %d and %d SOA records", n, got, soas)
+	}
+}
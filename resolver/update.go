@@ -0,0 +1,295 @@
+package resolver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/metrics"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+var updates = metrics.NewCounterVec("mesos_dns_updates_total",
+	"DNS UPDATE messages answered, by response code.", "rcode")
+
+// handleUpdate answers a dynamic update (RFC 2136) of the domain, applying
+// it to the update store. Updates must be signed with a TSIG key of the
+// configuration.
+func (res *Resolver) handleUpdate(w dns.ResponseWriter, r *dns.Msg, start time.Time) {
+	m := new(dns.Msg)
+	m.SetRcode(r, res.update(w, r, m))
+	m.Opcode = dns.OpcodeUpdate
+
+	// only answers to verified updates are signed
+	if t := r.IsTsig(); t != nil && m.Rcode != dns.RcodeRefused && m.Rcode != dns.RcodeNotAuth {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+
+	updates.With(rcodeString(m.Rcode)).Inc()
+	observeQuery(res.config().Domain+".", r, m, start, mesosLatency)
+	res.logQuery(w, r, m, start, logging.SourceLocal)
+	if err := w.WriteMsg(m); err != nil {
+		logging.Error.Println(err)
+	}
+}
+
+// update applies the update r, returning the response code. The granted
+// lease is added to m when the client asked for one.
+func (res *Resolver) update(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) int {
+	conf := res.config().Updates
+	if res.Updates == nil || conf.Secrets() == nil {
+		return dns.RcodeRefused
+	}
+	t := r.IsTsig()
+	if t == nil {
+		logging.VeryVerbose.Printf("refused unsigned update from %s\n", w.RemoteAddr())
+		return dns.RcodeRefused
+	}
+	if err := w.TsigStatus(); err != nil || !strings.EqualFold(conf.Algorithm(t.Hdr.Name), t.Algorithm) {
+		logging.Verbose.Printf("update from %s with bad signature of key %s: %v\n", w.RemoteAddr(), t.Hdr.Name, err)
+		return dns.RcodeNotAuth
+	}
+
+	zone := res.config().Domain + "."
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError
+	}
+	if !strings.EqualFold(r.Question[0].Name, zone) {
+		return dns.RcodeNotAuth
+	}
+
+	// no other update may change the records between the check of the
+	// prerequisites and the merge of the result
+	res.updateLock.Lock()
+	defer res.updateLock.Unlock()
+	if rcode := res.prerequisites(r.Answer, zone); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+
+	// clients may ask for a shorter lease with the update lease option
+	lease := time.Duration(conf.LeaseSeconds) * time.Second
+	if ul := updateLease(r); ul != nil {
+		if asked := time.Duration(ul.Lease) * time.Second; asked > 0 && asked < lease {
+			lease = asked
+		}
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_UL{Code: dns.EDNS0UL, Lease: uint32(lease / time.Second)})
+	}
+
+	ops, rcode := res.updateOps(r.Ns, zone, lease)
+	if rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	if err := res.Updates.Apply(ops, time.Now()); err != nil {
+		logging.Error.Println("cannot apply update: ", err)
+		return dns.RcodeServerFailure
+	}
+	logging.Verbose.Printf("applied update of %d records signed with key %s\n", len(ops), t.Hdr.Name)
+
+	if err := res.refreshSource(res.Updates); err != nil {
+		logging.Error.Printf("cannot refresh source %s: %v\n", res.Updates.Name(), err)
+	}
+	res.merge()
+	return dns.RcodeSuccess
+}
+
+// updateLease returns the update lease option of r, nil without
+func updateLease(r *dns.Msg) *dns.EDNS0_UL {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if ul, ok := o.(*dns.EDNS0_UL); ok {
+			return ul
+		}
+	}
+	return nil
+}
+
+// prerequisites checks the prerequisites of an update against the served
+// records (RFC 2136 section 3.2), returning the response code
+func (res *Resolver) prerequisites(prereqs []dns.RR, zone string) int {
+	rs := res.records()
+	type rrset struct{ name, rtype string }
+	wanted := make(map[rrset][]string)
+	for _, rr := range prereqs {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		if !dns.IsSubDomain(zone, name) {
+			return dns.RcodeNotZone
+		}
+		if h.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		rtype := typeString(h.Rrtype)
+		switch h.Class {
+		case dns.ClassANY:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY && !inUse(rs, name) {
+				return dns.RcodeNameError
+			}
+			if h.Rrtype != dns.TypeANY && len(served(rs, name, rtype)) == 0 {
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if h.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if h.Rrtype == dns.TypeANY && inUse(rs, name) {
+				return dns.RcodeYXDomain
+			}
+			if h.Rrtype != dns.TypeANY && len(served(rs, name, rtype)) > 0 {
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			rec, ok := records.NewRecord(rr, records.SourceUpdates)
+			if !ok {
+				return dns.RcodeNXRrset
+			}
+			set := rrset{rec.Name, rec.Type}
+			wanted[set] = append(wanted[set], rec.Target)
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	// value dependent prerequisites need the very same rrsets
+	for set, targets := range wanted {
+		if !sameTargets(served(rs, set.name, set.rtype), targets) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// updateOps returns the changes of the update section of an update (RFC
+// 2136 section 3.4), or the response code refusing them. Names of the
+// tasks, of their frameworks and of the self-check records can't be
+// updated.
+func (res *Resolver) updateOps(rrs []dns.RR, zone string, lease time.Duration) ([]records.UpdateOp, int) {
+	protected := res.protectedNames()
+	var ops []records.UpdateOp
+	for _, rr := range rrs {
+		h := rr.Header()
+		name := strings.ToLower(h.Name)
+		if !dns.IsSubDomain(zone, name) {
+			return nil, dns.RcodeNotZone
+		}
+		if protected(name) {
+			logging.Verbose.Printf("refused update of task name %s\n", name)
+			return nil, dns.RcodeRefused
+		}
+
+		var op records.UpdateOp
+		switch h.Class {
+		case dns.ClassINET:
+			rec, ok := records.NewRecord(rr, records.SourceUpdates)
+			if !ok {
+				return nil, dns.RcodeRefused
+			}
+			op = records.UpdateOp{Record: rec, Lease: lease}
+		case dns.ClassANY:
+			if h.Ttl != 0 || h.Rdlength != 0 {
+				return nil, dns.RcodeFormatError
+			}
+			op = records.UpdateOp{Delete: true, Record: records.Record{Name: name}}
+			if h.Rrtype != dns.TypeANY {
+				op.Record.Type = typeString(h.Rrtype)
+			}
+		case dns.ClassNONE:
+			if h.Ttl != 0 {
+				return nil, dns.RcodeFormatError
+			}
+			rec, ok := records.NewRecord(rr, records.SourceUpdates)
+			if !ok {
+				// no records of other types to delete
+				continue
+			}
+			op = records.UpdateOp{Delete: true, Record: rec}
+		default:
+			return nil, dns.RcodeFormatError
+		}
+		ops = append(ops, op)
+	}
+	return ops, dns.RcodeSuccess
+}
+
+// protectedNames returns whether updates can't touch a name: those of
+// the tasks and every name under their framework subdomains, the domain
+// itself and the self-check records
+func (res *Resolver) protectedNames() func(string) bool {
+	domain := res.config().Domain + "."
+	names := map[string]bool{
+		domain:           true,
+		res.statusName(): true,
+		res.healthName(): true,
+	}
+	frameworks := make(map[string]bool)
+	res.srcsLock.Lock()
+	if t := res.srcs.mesos; t != nil {
+		for _, rrs := range []map[string][]string{t.As, t.SRVs, t.CNAMEs} {
+			for name := range rrs {
+				names[name] = true
+				// web.marathon.mesos. is under the framework marathon, unlike
+				// _leader._tcp.mesos.
+				labels := dns.SplitDomainName(strings.TrimSuffix(name, "."+domain))
+				if fw := labels[len(labels)-1]; len(labels) > 1 && !strings.HasPrefix(fw, "_") {
+					frameworks[fw+"."+domain] = true
+				}
+			}
+		}
+	}
+	res.srcsLock.Unlock()
+
+	return func(name string) bool {
+		if names[name] {
+			return true
+		}
+		for fw := range frameworks {
+			if dns.IsSubDomain(fw, name) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// served returns the targets of the rrset of name and type rtype that are
+// served by rs
+func served(rs *records.RecordGenerator, name, rtype string) []string {
+	switch rtype {
+	case records.TypeA:
+		return rs.As[name]
+	case records.TypeSRV:
+		return rs.SRVs[name]
+	case records.TypeCNAME:
+		return rs.CNAMEs[name]
+	}
+	return nil
+}
+
+// inUse tells whether rs serves any record of name
+func inUse(rs *records.RecordGenerator, name string) bool {
+	return len(rs.As[name]) > 0 || len(rs.SRVs[name]) > 0 || len(rs.CNAMEs[name]) > 0
+}
+
+// sameTargets tells whether a and b hold the same targets, in any order
+func sameTargets(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, t := range a {
+		set[t] = true
+	}
+	other := make(map[string]bool, len(b))
+	for _, t := range b {
+		if !set[t] {
+			return false
+		}
+		other[t] = true
+	}
+	return len(set) == len(other)
+}
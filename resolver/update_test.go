@@ -0,0 +1,161 @@
+package resolver
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+func TestUpdate(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+	res := New(records.Config{
+		Domain: "mesos",
+		TTL:    60,
+		Updates: records.UpdatesConfig{
+			Keys:         []records.TSIGKey{{Name: "Update.", Secret: secret}},
+			LeaseSeconds: 60,
+		},
+	})
+	res.Updates, _ = records.NewUpdateStore("")
+	res.Sources = []records.RecordSource{res.Updates}
+	res.restoreMesos(&records.RecordGenerator{
+		As: map[string][]string{"web.marathon.mesos.": {"10.0.0.1"}},
+	}, time.Now())
+	res.merge()
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc("mesos.", res.HandleMesos)
+	server, err := res.Listen("udp", "127.0.0.1:0", mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go res.Serve(server)
+	servedServer(t, res, server)
+	addr := server.PacketConn.LocalAddr().String()
+
+	send := func(m *dns.Msg, key, secret string) int {
+		c := &dns.Client{Net: "udp"}
+		if key != "" {
+			c.TsigSecret = map[string]string{key: secret}
+			m.SetTsig(key, dns.HmacSHA256, 300, time.Now().Unix())
+		}
+		in, _, err := c.Exchange(m, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return in.Rcode
+	}
+	update := func(rrs ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetUpdate("mesos.")
+		for _, s := range rrs {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Insert([]dns.RR{rr})
+		}
+		return m
+	}
+
+	for _, tt := range []struct {
+		msg    *dns.Msg
+		key    string
+		secret string
+		rcode  int
+	}{
+		{update("registry.mesos. 60 IN A 10.0.0.9"), "", "", dns.RcodeRefused},
+		{update("registry.mesos. 60 IN A 10.0.0.9"), "update.", base64.StdEncoding.EncodeToString([]byte("wrong")), dns.RcodeNotAuth},
+		{update("web.marathon.mesos. 60 IN A 10.0.0.9"), "update.", secret, dns.RcodeRefused},
+		{update("api.marathon.mesos. 60 IN A 10.0.0.9"), "update.", secret, dns.RcodeRefused},
+		{update("registry.example.com. 60 IN A 10.0.0.9"), "update.", secret, dns.RcodeNotZone},
+		{update("registry.mesos. 60 IN TXT \"x\""), "update.", secret, dns.RcodeRefused},
+		{update("registry.mesos. 60 IN A 10.0.0.9"), "Update.", secret, dns.RcodeSuccess},
+		{update("registry.mesos. 60 IN A 10.0.0.9"), "update.", secret, dns.RcodeSuccess},
+		// the server only knows the configured and the lower case spellings
+		{update("registry.mesos. 60 IN A 10.0.0.9"), "UPDATE.", secret, dns.RcodeNotAuth},
+	} {
+		if rcode := send(tt.msg, tt.key, tt.secret); rcode != tt.rcode {
+			t.Errorf("%v: want %s, got %s", tt.msg.Ns, rcodeString(tt.rcode), rcodeString(rcode))
+		}
+	}
+	if as := res.records().As["registry.mesos."]; len(as) != 1 || as[0] != "10.0.0.9" {
+		t.Error("should serve the added record, got ", as)
+	}
+
+	// the name is now in use
+	m := update("registry.mesos. 60 IN A 10.0.0.10")
+	rr, _ := dns.NewRR("registry.mesos. 0 IN A 0.0.0.0")
+	m.NameNotUsed([]dns.RR{rr})
+	if rcode := send(m, "update.", secret); rcode != dns.RcodeYXDomain {
+		t.Error("should check prerequisites, got ", rcodeString(rcode))
+	}
+
+	m = new(dns.Msg)
+	m.SetUpdate("mesos.")
+	m.RemoveName([]dns.RR{rr})
+	if rcode := send(m, "update.", secret); rcode != dns.RcodeSuccess {
+		t.Error("should delete the name, got ", rcodeString(rcode))
+	}
+	if as := res.records().As["registry.mesos."]; len(as) != 0 {
+		t.Error("should not serve deleted records, got ", as)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+	res := New(records.Config{
+		Domain: "mesos",
+		Updates: records.UpdatesConfig{
+			Keys:         []records.TSIGKey{{Name: "update.", Secret: secret}},
+			LeaseSeconds: 60,
+		},
+	})
+	res.Updates, _ = records.NewUpdateStore("")
+	res.Sources = []records.RecordSource{res.Updates}
+
+	// every update adds the same name unless it's in use already, along
+	// with a name of its own, while the sources are merged concurrently
+	const n = 20
+	rcodes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			m := new(dns.Msg)
+			m.SetUpdate("mesos.")
+			rr, _ := dns.NewRR("registry.mesos. 0 IN A 0.0.0.0")
+			m.NameNotUsed([]dns.RR{rr})
+			add, _ := dns.NewRR("registry.mesos. 60 IN A 10.0.0.9")
+			own, _ := dns.NewRR(fmt.Sprintf("host%d.mesos. 60 IN A 10.0.1.%d", i, i))
+			m.Insert([]dns.RR{add, own})
+			m.SetTsig("update.", dns.HmacSHA256, 300, time.Now().Unix())
+			rcodes <- res.update(udpClient("127.0.0.1"), m, new(dns.Msg))
+		}(i)
+		go func() {
+			defer wg.Done()
+			res.RefreshSources()
+		}()
+	}
+	wg.Wait()
+	close(rcodes)
+
+	succeeded := 0
+	for rcode := range rcodes {
+		if rcode == dns.RcodeSuccess {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("exactly one update should pass its prerequisites, %d did", succeeded)
+	}
+	if as := res.records().As["registry.mesos."]; len(as) != 1 {
+		t.Error("should serve the added record, got ", as)
+	}
+}
@@ -0,0 +1,105 @@
+package resolver
+
+import (
+	"net"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// view is a split-horizon view: the clients it answers, how it chooses
+// slave addresses and where it forwards queries outside the domain
+type view struct {
+	name      string
+	clients   acl
+	policy    string
+	resolvers []string
+}
+
+// newViews returns the configured views, in order of precedence
+func newViews(config []records.ViewConfig) []*view {
+	views := make([]*view, 0, len(config))
+	for _, v := range config {
+		views = append(views, &view{
+			name:      v.Name,
+			clients:   parseACL(v.Clients),
+			policy:    v.AddressPolicy,
+			resolvers: v.Resolvers,
+		})
+	}
+	return views
+}
+
+// selectView returns the first view that answers the client of r, or nil
+// for the default view. The address from an EDNS Client Subnet option
+// stands in for the client if the query came from a trusted forwarder.
+func (res *Resolver) selectView(w dns.ResponseWriter, r *dns.Msg) *view {
+	if len(res.views) == 0 {
+		return nil
+	}
+
+	ip := clientIP(w)
+	if res.ecsForwarders != nil && res.ecsForwarders.allows(ip) {
+		if subnet := clientSubnet(r); subnet != nil {
+			ip = subnet
+		}
+	}
+	if ip == nil {
+		return nil
+	}
+
+	for _, v := range res.views {
+		if v.clients != nil && v.clients.allows(ip) {
+			return v
+		}
+	}
+	return nil
+}
+
+// clientSubnet returns the address of the EDNS Client Subnet option of r
+func clientSubnet(r *dns.Msg) net.IP {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if subnet, ok := o.(*dns.EDNS0_SUBNET); ok && len(subnet.Address) > 0 {
+			return subnet.Address
+		}
+	}
+	return nil
+}
+
+// projectViews returns the A records of rs as seen by every view that
+// chooses slave addresses differently from the default
+func projectViews(views []*view, rs *records.RecordGenerator) map[string]map[string][]string {
+	projections := make(map[string]map[string][]string, len(views))
+	for _, v := range views {
+		if v.policy != "" && v.policy != records.PolicyHostname {
+			projections[v.name] = rs.Project(v.policy)
+		}
+	}
+	return projections
+}
+
+// viewRecords returns the current records along with the A records as
+// seen by v (the default view if nil)
+func (res *Resolver) viewRecords(v *view) (*records.RecordGenerator, map[string][]string) {
+	res.rsLock.RLock()
+	defer res.rsLock.RUnlock()
+
+	if v != nil {
+		if as, ok := res.projections[v.name]; ok {
+			return res.rs, as
+		}
+	}
+	return res.rs, res.rs.As
+}
+
+// forwardTo returns the resolvers queries of v are forwarded to
+func (res *Resolver) forwardTo(v *view) []string {
+	if v != nil && v.resolvers != nil {
+		return v.resolvers
+	}
+	return res.config().Resolvers
+}
@@ -0,0 +1,83 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+func viewResolver() *Resolver {
+	return New(records.Config{
+		Domain:    "mesos",
+		Resolvers: []string{"10.0.0.53"},
+		Views: []records.ViewConfig{
+			{Name: "internal", Clients: []string{"10.0.0.0/8"}, AddressPolicy: records.PolicyIP},
+			{Name: "public", Clients: []string{"0.0.0.0/0"}, AddressPolicy: "attribute:public_ip",
+				Resolvers: []string{}},
+		},
+		ECSForwarders: []string{"10.0.0.53"},
+	})
+}
+
+func TestSelectView(t *testing.T) {
+	res := viewResolver()
+	r := new(dns.Msg)
+	r.SetQuestion("web.marathon.mesos.", dns.TypeA)
+
+	if v := res.selectView(udpClient("10.1.2.3"), r); v == nil || v.name != "internal" {
+		t.Error("should select the internal view")
+	}
+	if v := res.selectView(udpClient("203.0.113.9"), r); v == nil || v.name != "public" {
+		t.Error("should select the public view")
+	}
+
+	// an external client behind a trusted forwarder
+	o := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	o.Option = append(o.Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("203.0.113.0").To4(),
+	})
+	r.Extra = append(r.Extra, o)
+
+	if v := res.selectView(udpClient("10.0.0.53"), r); v == nil || v.name != "public" {
+		t.Error("should select the view of the client subnet of a trusted forwarder")
+	}
+	if v := res.selectView(udpClient("10.0.0.54"), r); v == nil || v.name != "internal" {
+		t.Error("should ignore the client subnet of untrusted forwarders")
+	}
+
+	if rs := res.forwardTo(res.views[1]); len(rs) != 0 {
+		t.Error("the public view should not forward queries")
+	}
+	if rs := res.forwardTo(nil); len(rs) != 1 {
+		t.Error("the default view should forward to the global resolvers")
+	}
+}
+
+func TestHandleMesosView(t *testing.T) {
+	res := viewResolver()
+	rs := &records.RecordGenerator{
+		Slaves: records.Slaves{},
+		As:     map[string][]string{"web.marathon.mesos.": {"10.0.0.1"}},
+	}
+	res.rs = rs
+	res.projections = map[string]map[string][]string{
+		"public": {"web.marathon.mesos.": {"203.0.113.1"}},
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("web.marathon.mesos.", dns.TypeA)
+
+	w := udpClient("203.0.113.9")
+	res.HandleMesos(w, r)
+	if len(w.msg.Answer) != 1 || w.msg.Answer[0].(*dns.A).A.String() != "203.0.113.1" {
+		t.Error("should answer from the public projection, got ", w.msg.Answer)
+	}
+
+	w = udpClient("10.1.2.3")
+	res.HandleMesos(w, r)
+	if len(w.msg.Answer) != 1 || w.msg.Answer[0].(*dns.A).A.String() != "10.0.0.1" {
+		t.Error("should fall back to the generation for views without projection, got ", w.msg.Answer)
+	}
+}
@@ -0,0 +1,333 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+const (
+	// watchHistory bounds the number of endpoint events kept around for
+	// watchers that reconnect; older versions get a fresh snapshot instead
+	watchHistory = 10000
+
+	// watchKeepalive is the interval of comments sent on idle event streams
+	watchKeepalive = 15 * time.Second
+
+	// watchDefaultWait and watchMaxWait bound how long a long-poll blocks
+	watchDefaultWait = 30 * time.Second
+	watchMaxWait     = 5 * time.Minute
+)
+
+// watchEvent is the addition or removal of a single endpoint of a name
+type watchEvent struct {
+	Version  uint64 `json:"version"`
+	Op       string `json:"op"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+}
+
+// watchUpdate is what a watcher receives: either the events since the
+// version it knows about, or a snapshot (Reset) of the current endpoints
+// expressed as "add" events
+type watchUpdate struct {
+	Version uint64       `json:"version"`
+	Reset   bool         `json:"reset"`
+	Events  []watchEvent `json:"events"`
+}
+
+// watchBatch holds the events published by a single Reload
+type watchBatch struct {
+	version uint64
+	events  []watchEvent
+}
+
+// watchHub tracks endpoint changes between record generations and wakes up
+// watchers whenever a generation changes anything
+type watchHub struct {
+	sync.Mutex
+	rs      *records.RecordGenerator
+	version uint64
+	floor   uint64
+	batches []watchBatch
+	size    int
+	changed chan struct{}
+}
+
+// newWatchHub returns an empty hub. Versions start at the current time so
+// that versions handed out by a previous process are never mistaken for
+// resumable ones.
+func newWatchHub() *watchHub {
+	v := uint64(time.Now().UnixNano())
+	return &watchHub{
+		rs:      &records.RecordGenerator{},
+		version: v,
+		floor:   v,
+		changed: make(chan struct{}),
+	}
+}
+
+// publish makes rs the current generation and records the endpoints that
+// were added or removed compared to the previous one
+func (h *watchHub) publish(rs *records.RecordGenerator) {
+	h.Lock()
+	defer h.Unlock()
+
+	var evs []watchEvent
+	evs = diffEndpoints(evs, "A", h.rs.As, rs.As)
+	evs = diffEndpoints(evs, "SRV", h.rs.SRVs, rs.SRVs)
+	h.rs = rs
+
+	if len(evs) == 0 {
+		return
+	}
+
+	h.version++
+	for i := range evs {
+		evs[i].Version = h.version
+	}
+	h.batches = append(h.batches, watchBatch{version: h.version, events: evs})
+	h.size += len(evs)
+
+	// drop whole batches so that any retained version resumes exactly
+	for len(h.batches) > 1 && h.size > watchHistory {
+		h.floor = h.batches[0].version
+		h.size -= len(h.batches[0].events)
+		h.batches = h.batches[1:]
+	}
+
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// poll returns the next update for a watcher of names that last saw
+// version since (resume is false for new watchers), along with a channel
+// that is closed once a newer generation is published
+func (h *watchHub) poll(names map[string]bool, since uint64, resume bool) (watchUpdate, <-chan struct{}) {
+	h.Lock()
+	defer h.Unlock()
+
+	u := watchUpdate{Version: h.version}
+	if !resume || since < h.floor || since > h.version {
+		u.Reset = true
+		u.Events = snapshotEndpoints(h.rs, names, h.version)
+		return u, h.changed
+	}
+
+	for _, b := range h.batches {
+		if b.version <= since {
+			continue
+		}
+		for _, ev := range b.events {
+			if names[ev.Name] {
+				u.Events = append(u.Events, ev)
+			}
+		}
+	}
+	return u, h.changed
+}
+
+// diffEndpoints appends add and remove events for every endpoint that
+// differs between prev and next
+func diffEndpoints(evs []watchEvent, rtype string, prev, next map[string][]string) []watchEvent {
+	for name, vals := range next {
+		old := endpointSet(prev[name])
+		for _, v := range uniqueEndpoints(vals) {
+			if !old[v] {
+				evs = append(evs, watchEvent{Op: "add", Name: name, Type: rtype, Endpoint: v})
+			}
+		}
+	}
+	for name, vals := range prev {
+		cur := endpointSet(next[name])
+		for _, v := range uniqueEndpoints(vals) {
+			if !cur[v] {
+				evs = append(evs, watchEvent{Op: "remove", Name: name, Type: rtype, Endpoint: v})
+			}
+		}
+	}
+	return evs
+}
+
+// snapshotEndpoints returns the current endpoints of names as add events
+func snapshotEndpoints(rs *records.RecordGenerator, names map[string]bool, version uint64) []watchEvent {
+	evs := []watchEvent{}
+	for name := range names {
+		for _, v := range uniqueEndpoints(rs.As[name]) {
+			evs = append(evs, watchEvent{Version: version, Op: "add", Name: name, Type: "A", Endpoint: v})
+		}
+		for _, v := range uniqueEndpoints(rs.SRVs[name]) {
+			evs = append(evs, watchEvent{Version: version, Op: "add", Name: name, Type: "SRV", Endpoint: v})
+		}
+	}
+	return evs
+}
+
+func endpointSet(vals []string) map[string]bool {
+	set := make(map[string]bool, len(vals))
+	for _, v := range vals {
+		set[v] = true
+	}
+	return set
+}
+
+// uniqueEndpoints returns vals without duplicates in a stable order
+func uniqueEndpoints(vals []string) []string {
+	set := endpointSet(vals)
+	u := make([]string, 0, len(set))
+	for v := range set {
+		u = append(u, v)
+	}
+	sort.Strings(u)
+	return u
+}
+
+// watchNames collects the names to watch from the request, accepting both
+// repeated and comma separated name parameters
+func watchNames(r *http.Request) map[string]bool {
+	names := make(map[string]bool)
+	for _, param := range r.URL.Query()["name"] {
+		for _, name := range strings.Split(param, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names[dns.Fqdn(strings.ToLower(name))] = true
+			}
+		}
+	}
+	return names
+}
+
+// watchSince returns the version a reconnecting watcher last saw, taken
+// from the since parameter or the Last-Event-ID header of event streams
+func watchSince(r *http.Request) (uint64, bool) {
+	s := r.URL.Query().Get("since")
+	if s == "" {
+		s = r.Header.Get("Last-Event-ID")
+	}
+	if s == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// HandleWatch serves endpoint changes for the names given in the request,
+// as server-sent events when the client accepts text/event-stream and as
+// a long-poll otherwise
+func (res *Resolver) HandleWatch(w http.ResponseWriter, r *http.Request) {
+	names := watchNames(r)
+	if len(names) == 0 {
+		http.Error(w, "missing name parameter", http.StatusBadRequest)
+		return
+	}
+	since, resume := watchSince(r)
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		res.watchStream(w, r, names, since, resume)
+	} else {
+		res.watchPoll(w, r, names, since, resume)
+	}
+}
+
+// watchPoll blocks until there is an update for names or the wait
+// parameter (in seconds) expires, and answers with a single update
+func (res *Resolver) watchPoll(w http.ResponseWriter, r *http.Request, names map[string]bool, since uint64, resume bool) {
+	wait := watchDefaultWait
+	if s := r.URL.Query().Get("wait"); s != "" {
+		secs, err := strconv.Atoi(s)
+		if err != nil || secs < 0 {
+			http.Error(w, "invalid wait parameter", http.StatusBadRequest)
+			return
+		}
+		wait = time.Duration(secs) * time.Second
+		if wait > watchMaxWait {
+			wait = watchMaxWait
+		}
+	}
+	timeout := time.After(wait)
+
+	for {
+		u, changed := res.watch.poll(names, since, resume)
+		if u.Reset || len(u.Events) > 0 {
+			writeWatchJSON(w, u)
+			return
+		}
+		since = u.Version
+
+		select {
+		case <-changed:
+		case <-timeout:
+			writeWatchJSON(w, u)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// watchStream sends updates for names as server-sent events until the
+// client goes away; event ids are versions so reconnects resume
+func (res *Resolver) watchStream(w http.ResponseWriter, r *http.Request, names map[string]bool, since uint64, resume bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(watchKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		u, changed := res.watch.poll(names, since, resume)
+		if u.Reset || len(u.Events) > 0 {
+			event := "update"
+			if u.Reset {
+				event = "reset"
+			}
+			b, err := json.Marshal(u)
+			if err != nil {
+				return
+			}
+			if _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", u.Version, event, b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		since, resume = u.Version, true
+
+		select {
+		case <-changed:
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeWatchJSON(w http.ResponseWriter, u watchUpdate) {
+	if u.Events == nil {
+		u.Events = []watchEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(u)
+}
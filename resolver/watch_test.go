@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+func watchRecords(as map[string][]string, srvs map[string][]string) *records.RecordGenerator {
+	return &records.RecordGenerator{As: as, SRVs: srvs}
+}
+
+func TestWatchHubPublish(t *testing.T) {
+	h := newWatchHub()
+	names := map[string]bool{"web.marathon.mesos.": true}
+
+	h.publish(watchRecords(map[string][]string{
+		"web.marathon.mesos.": {"10.0.0.1"},
+		"db.marathon.mesos.":  {"10.0.0.9"},
+	}, nil))
+
+	u, _ := h.poll(names, 0, false)
+	if !u.Reset || len(u.Events) != 1 || u.Events[0].Endpoint != "10.0.0.1" {
+		t.Errorf("expected initial snapshot with one endpoint, got %+v", u)
+	}
+	since := u.Version
+
+	h.publish(watchRecords(map[string][]string{
+		"web.marathon.mesos.": {"10.0.0.2"},
+		"db.marathon.mesos.":  {"10.0.0.8"},
+	}, nil))
+
+	u, _ = h.poll(names, since, true)
+	if u.Reset {
+		t.Error("should resume instead of sending a snapshot")
+	}
+	if len(u.Events) != 2 {
+		t.Fatalf("expected an add and a remove event, got %+v", u.Events)
+	}
+	for _, ev := range u.Events {
+		switch ev.Op {
+		case "add":
+			if ev.Endpoint != "10.0.0.2" {
+				t.Error("wrong endpoint added: ", ev.Endpoint)
+			}
+		case "remove":
+			if ev.Endpoint != "10.0.0.1" {
+				t.Error("wrong endpoint removed: ", ev.Endpoint)
+			}
+		default:
+			t.Error("unexpected op: ", ev.Op)
+		}
+	}
+
+	// a version this hub never handed out can't be resumed
+	u, _ = h.poll(names, since-100, true)
+	if !u.Reset {
+		t.Error("should send a snapshot for an unknown version")
+	}
+}
+
+func TestWatchHubUnchanged(t *testing.T) {
+	h := newWatchHub()
+	rs := watchRecords(map[string][]string{"web.marathon.mesos.": {"10.0.0.1"}}, nil)
+	h.publish(rs)
+	v := h.version
+
+	_, changed := h.poll(map[string]bool{"web.marathon.mesos.": true}, v, true)
+	h.publish(watchRecords(map[string][]string{"web.marathon.mesos.": {"10.0.0.1"}}, nil))
+
+	if h.version != v {
+		t.Error("identical generations should not bump the version")
+	}
+	select {
+	case <-changed:
+		t.Error("identical generations should not wake up watchers")
+	default:
+	}
+}
+
+func TestHandleWatchLongPoll(t *testing.T) {
+	res := New(records.Config{})
+	res.watch.publish(watchRecords(nil, map[string][]string{
+		"_web._tcp.marathon.mesos.": {"web-s1.marathon.mesos.:31000"},
+	}))
+	since := res.watch.version
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		res.watch.publish(watchRecords(nil, map[string][]string{
+			"_web._tcp.marathon.mesos.": {"web-s1.marathon.mesos.:31000", "web-s2.marathon.mesos.:31001"},
+		}))
+	}()
+
+	req, _ := http.NewRequest("GET", "/v1/watch?name=_web._tcp.marathon.mesos&wait=5&since="+
+		strconv.FormatUint(since, 10), nil)
+	w := httptest.NewRecorder()
+	res.HandleWatch(w, req)
+
+	var u watchUpdate
+	if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Reset || len(u.Events) != 1 {
+		t.Fatalf("expected a single incremental event, got %+v", u)
+	}
+	if ev := u.Events[0]; ev.Op != "add" || ev.Type != "SRV" || ev.Endpoint != "web-s2.marathon.mesos.:31001" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestHandleWatchMissingName(t *testing.T) {
+	res := New(records.Config{})
+	req, _ := http.NewRequest("GET", "/v1/watch", nil)
+	w := httptest.NewRecorder()
+	res.HandleWatch(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Error("should reject watches without names")
+	}
+}